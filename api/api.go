@@ -14,6 +14,7 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -24,6 +25,8 @@ type API struct {
 	ws       chan message      // WebSocket channel. Any events sent
 	config   Config            // configuration - see docs/config for full documentation
 	protocol string            // this is set to either 'http' or 'https' depending on config.TLSConfig
+
+	dispatching sync.WaitGroup // dispatches of stages to services that are still in progress
 }
 
 // New creates an instance of the Houston API object.
@@ -106,7 +109,7 @@ func New(configPath string) *API {
 		}
 	}
 
-	a := API{db, nil, nil, config, protocol}
+	a := API{db: db, config: config, protocol: protocol}
 
 	log.Debugf("API will use the %s protocol", protocol)
 
@@ -270,9 +273,9 @@ func (a *API) CreateMissionFromPlan(key string, planNameOrPlan string, missionId
 	}
 
 	var plan model.Plan
-	err := json.Unmarshal(planBytes, &plan) // this will catch any invalid params, services, etc.
+	err := decodeJSON(planBytes, &plan) // this will catch any invalid params, services, etc.
 	if err != nil {
-		return "", err // TODO: catch json/schema errors and give helpful response
+		return "", err
 	}
 
 	if strings.ContainsAny(plan.Name, disallowedCharacters) {
@@ -343,8 +346,16 @@ func (a *API) CreateMissionFromPlan(key string, planNameOrPlan string, missionId
 	m.Id = missionId
 	m.Start = time.Now()
 
-	// TODO: set mission parameters
-	m.Params = missionParameters
+	// mission params override the plan's params, and are overridden by each stage's params when the stage is started
+	m.Params = mergeParams(plan.Params, missionParameters)
+	if err := m.Validate(); err != nil {
+		return "", err // the mission params may contain invalid templates
+	}
+
+	// map stages without upstream stages can be expanded straight away
+	if err := m.ExpandMapStages(); err != nil {
+		return "", err
+	}
 
 	// TODO: this could only be a database connection error - these should be retried at least 3 times
 	err = a.db.Set(key, m.Id, string(m.Bytes()))
@@ -456,35 +467,67 @@ func (a *API) AllActiveMissions(key string) ([]string, error) {
 }
 
 // UpdateStageState updates the state of a stage within an in-progress mission.
-// POST /api/missions/[mission id]/stages/[stage name]
 func (a *API) UpdateStageState(key string, missionId string, stage string, state string, ignoreDependencies bool) (mission.Response, error) {
+	return a.UpdateStage(key, missionId, stage, model.MissionStageStateUpdate{State: state, IgnoreDependencies: ignoreDependencies})
+}
+
+// UpdateStage updates the state of a stage within an in-progress mission using all the options in the update request.
+// POST /api/missions/[mission id]/stages/[stage name]
+func (a *API) UpdateStage(key string, missionId string, stage string, update model.MissionStageStateUpdate) (mission.Response, error) {
+	state := update.State
+	ignoreDependencies := update.IgnoreDependencies
 	keyLog.Debugf("Updating stage '%s' state to '%s' in mission '%s'.", stage, state, missionId)
 
 	var res mission.Response
 	var missionBytes []byte
+	reopened := false // true if a completed mission was reopened by resetting a stage
 
 	// define a function to perform on a mission within a transaction
 	txnFunc := func(missionString string) (string, error) {
+		reopened = false
 
 		m, err := mission.NewFromJSON([]byte(missionString))
 		if err != nil {
 			// an error here is unlikely because all missions are validated before they get saved
 			return "", err // TODO: catch json/schema errors and give helpful response
 		}
+		m.Annotate(update.Caller, update.Message)
 
 		switch state {
 		case "started":
 			res, err = m.StartStage(stage, ignoreDependencies)
 		case "finished":
+			if err := m.CheckLease(stage, update.Worker); err != nil {
+				return "", err
+			}
+			if update.Outputs != nil {
+				if err := m.SetOutputs(stage, update.Outputs); err != nil {
+					return "", err
+				}
+			}
 			res, err = m.FinishStage(stage, ignoreDependencies)
 		case "skipped":
 			res, err = m.SkipStage(stage)
 		case "failed":
-			res, err = m.FailStage(stage)
+			if err := m.CheckLease(stage, update.Worker); err != nil {
+				return "", err
+			}
+			fatal := update.Fatal
+			if update.Error != nil {
+				stageError := mission.StageError(*update.Error)
+				if err := m.SetError(stage, &stageError); err != nil {
+					return "", err
+				}
+				fatal = fatal || (update.Error.Retryable != nil && !*update.Error.Retryable)
+			}
+			res, err = m.FailStage(stage, fatal)
 		case "excluded", "ignored":
 			res, err = m.ExcludeStage(stage)
+		case "reset", "ready":
+			reopened = !m.End.IsZero()
+			res, err = m.ResetStage(stage)
 		default:
-			err = fmt.Errorf("invalid stage state '%v'; choose one of started, finished, failed, skipped, or excluded", state)
+			err = fmt.Errorf("invalid stage state '%v'; choose one of started, finished, failed, skipped, excluded, or reset", state)
 		}
 
 		if err != nil {
@@ -534,9 +577,104 @@ func (a *API) UpdateStageState(key string, missionId string, stage string, state
 		err = a.updateActiveOrCompletedMissions(key, "c", "", []string{missionId}, nil)
 	}
 
+	// if the mission has been reopened, remove it from the list of missions to be cleaned up
+	if err == nil && reopened {
+		keyLog.Infof("Mission %s has been reopened", missionId)
+		err = a.updateActiveOrCompletedMissions(key, "c", "", nil, []string{missionId})
+	}
+
+	if err == nil {
+		// sub-plan stages run a child mission when started, and child missions update their parent stage when they end
+		if state == "started" {
+			err = a.startChildMission(key, missionId, stage, res.Params, missionBytes)
+		}
+		a.updateParentStage(key, missionBytes)
+		a.dispatchStages(key, res.Next, missionBytes)
+	}
+
 	return res, err
 }
 
+// PauseMission prevents any more stages in a mission from being started until it is resumed.
+// POST /api/missions/[mission id]/pause
+func (a *API) PauseMission(key string, missionId string) (mission.Response, error) {
+	return a.changeMissionState(key, missionId, "missionPaused", func(m *mission.Mission) (mission.Response, error) {
+		return m.Pause()
+	})
+}
+
+// ResumeMission allows stages in a paused mission to be started again.
+// POST /api/missions/[mission id]/resume
+func (a *API) ResumeMission(key string, missionId string) (mission.Response, error) {
+	return a.changeMissionState(key, missionId, "missionResumed", func(m *mission.Mission) (mission.Response, error) {
+		return m.Resume()
+	})
+}
+
+// CancelMission excludes all stages in a mission that haven't started and marks the mission as complete.
+// POST /api/missions/[mission id]/cancel
+func (a *API) CancelMission(key string, missionId string, reason string, actor string) (mission.Response, error) {
+	res, err := a.changeMissionState(key, missionId, "missionCancelled", func(m *mission.Mission) (mission.Response, error) {
+		m.Annotate(actor, "")
+		return m.Cancel(reason, actor)
+	})
+	if err != nil {
+		return res, err
+	}
+	if missionBytes, ok := a.db.Get(key, missionId); ok {
+		a.ws <- message{key, "missionCompleted", []byte(missionBytes)}
+	}
+	keyLog.Infof("Mission %s is complete", missionId)
+	return res, a.updateActiveOrCompletedMissions(key, "c", "", []string{missionId}, nil)
+}
+
+// changeMissionState runs a mission level state change within a transaction, then sends the updated mission to all
+// websocket clients as both a 'missionUpdate' event and the event given.
+func (a *API) changeMissionState(key string, missionId string, event string, f func(m *mission.Mission) (mission.Response, error)) (mission.Response, error) {
+	var res mission.Response
+	missionBytes, err := a.updateMission(key, missionId, func(m *mission.Mission) error {
+		var err error
+		res, err = f(m)
+		return err
+	})
+	if err != nil {
+		keyLog.Errorf("Error when changing the state of mission %s: %s", missionId, err)
+		return res, err
+	}
+	keyLog.Infof("Mission %s: %s", missionId, event)
+	a.ws <- message{key, "missionUpdate", missionBytes}
+	a.ws <- message{key, event, missionBytes}
+	a.updateParentStage(key, missionBytes)
+	return res, nil
+}
+
+// updateMission runs a function on a mission within a transaction, and returns the updated mission. The transaction
+// is retried if the mission is locked, in the same way as in UpdateStage.
+func (a *API) updateMission(key string, missionId string, f func(m *mission.Mission) error) ([]byte, error) {
+	var missionBytes []byte
+	txnFunc := func(missionString string) (string, error) {
+		m, err := mission.NewFromJSON([]byte(missionString))
+		if err != nil {
+			return "", err
+		}
+		if err := f(&m); err != nil {
+			return "", err
+		}
+		missionBytes = m.Bytes()
+		return string(missionBytes), nil
+	}
+
+	var err error
+	for attempts := 0; attempts < 3; attempts++ {
+		err = a.db.DoTransaction(txnFunc, key, missionId)
+		if _, locked := err.(*model.TransactionFailedError); !locked {
+			break
+		}
+		time.Sleep(10 * time.Millisecond * time.Duration((attempts+1)^2))
+	}
+	return missionBytes, err
+}
+
 // CompletedMissions returns a list all missionIds that are completed so that they can be archived and deleted.
 func (a *API) CompletedMissions(key string) []string {
 	completedListString, ok := a.db.Get(key, "c")
@@ -548,29 +686,103 @@ func (a *API) CompletedMissions(key string) []string {
 	return completedList
 }
 
-// SavePlan stores a new plan in the database if that plan is valid. Current behaviour is to overwrite existing plans.
+// SavePlan stores a new plan in the database if that plan is valid. Current behaviour is to overwrite existing plans,
+// but every version of the plan is kept and given a version number, along with the time it was saved and its author.
 // The 'active' key for the plan, and any existing missions, will be unaffected if the plan already exists.
-func (a *API) SavePlan(key string, plan model.Plan) error {
+func (a *API) SavePlan(key string, plan model.Plan, author string) error {
+	_, err := a.SavePlanIfMatch(key, plan, author, "")
+	return err
+}
+
+// LintPlan checks a plan without saving it, and returns every problem found, including warnings about parts of the
+// plan that are valid but are probably a mistake. See mission.Lint for the checks that are made.
+func (a *API) LintPlan(plan model.Plan) model.PlanLintResponse {
+	m := NewMissionFromPlan(&plan)
+	res := model.PlanLintResponse{Valid: true, Problems: m.Lint()}
+	for _, p := range res.Problems {
+		if p.Severity == mission.SeverityError {
+			res.Valid = false
+		}
+	}
+	return res
+}
 
-	// convert plan to mission for validation of graph only
+// SavePlanIfMatch saves a plan in the same way as SavePlan, but only if ifMatch is empty or matches the ETag of the
+// version of the plan that is currently saved, which prevents users from overwriting changes that they haven't seen.
+// The check and the write happen within a single transaction. Returns the new version number.
+func (a *API) SavePlanIfMatch(key string, plan model.Plan, author string, ifMatch string) (int, error) {
+
+	// convert plan to mission for validation of graph, and to compute the layout
+	plan.Layout = nil
 	m := NewMissionFromPlan(&plan)
 	err := m.Validate()
+	if err == nil {
+		err = m.ValidateSubPlans(a.subPlans(key))
+	}
+	if err == nil && a.config.Dispatcher.Enabled {
+		err = m.ValidateDispatch()
+	}
 	if err != nil {
-		return err
+		return 0, err
 	}
+	plan.Layout = m.Positions()
 
-	planBytes, _ := json.Marshal(plan)
-	keyLog.Infof("Converted Plan '%s' to Mission", plan.Name)
-	err = a.db.Set(key, "p|"+plan.Name, string(planBytes))
+	// plans that were deleted and saved again carry on from the last version, so that old versions aren't overwritten
+	latestVersion := a.latestPlanVersion(key, plan.Name)
 
+	var planBytes []byte
+	txnFunc := func(currentPlanString string) (string, error) {
+		var currentPlan model.Plan
+		if currentPlanString != "" {
+			json.Unmarshal([]byte(currentPlanString), &currentPlan)
+		}
+		if ifMatch != "" && !etagMatches(ifMatch, currentPlanString != "", currentPlan.Version) {
+			return "", &model.PreconditionFailedError{PlanName: plan.Name, ETag: planETag(currentPlan.Version)}
+		}
+		plan.Version = currentPlan.Version + 1
+		if plan.Version <= latestVersion {
+			plan.Version = latestVersion + 1
+		}
+		planBytes, _ = json.Marshal(plan)
+		return string(planBytes), nil
+	}
+
+	for attempts := 0; attempts < 3; attempts++ {
+		err = a.db.DoTransaction(txnFunc, key, "p|"+plan.Name)
+		if _, locked := err.(*model.TransactionFailedError); !locked {
+			break
+		}
+		time.Sleep(10 * time.Millisecond * time.Duration((attempts+1)^2))
+	}
 	if err != nil {
 		keyLog.Errorf("Error when saving plan to database: %v", err)
 		log.Warnf("User %s encountered error when saving plan to database: %v", key, err)
-		return err
+		return 0, err
 	}
-	keyLog.Infof("Plan '%s' has been saved.", plan.Name)
+	keyLog.Infof("Converted Plan '%s' to Mission", plan.Name)
+
+	err = a.db.Set(key, planVersionField(plan.Name, plan.Version), string(planBytes))
+	if err == nil {
+		err = a.recordPlanVersion(key, plan.Name, model.PlanVersion{Version: plan.Version, Time: time.Now(), Author: author})
+	}
+	if err != nil {
+		keyLog.Errorf("Error when creating a new version of plan '%s': %v", plan.Name, err)
+		return plan.Version, err
+	}
+	keyLog.Infof("Plan '%s' has been saved as version %v.", plan.Name, plan.Version)
 	a.ws <- message{key, "planCreation", planBytes}
-	return nil
+	return plan.Version, nil
+}
+
+// savedPlan returns the current version of a saved plan.
+func (a *API) savedPlan(key string, planName string) (model.Plan, error) {
+	var plan model.Plan
+	planString, ok := a.db.Get(key, "p|"+planName)
+	if !ok {
+		return plan, &model.PlanNotFoundError{PlanName: planName}
+	}
+	err := json.Unmarshal([]byte(planString), &plan)
+	return plan, err
 }
 
 // ListPlans returns all plan names.
@@ -7,9 +7,16 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"github.com/datasparq-ai/houston/mission"
 	"github.com/datasparq-ai/houston/model"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestAPI_CreateKey(t *testing.T) {
@@ -54,7 +61,7 @@ func TestAPI_CompletedMissions(t *testing.T) {
 		t.Fatalf("Failed to load plan")
 	}
 
-	err = api.SavePlan(key, plan)
+	err = api.SavePlan(key, plan, "")
 	if err != nil {
 		t.Fatalf("Failed to save plan")
 	}
@@ -96,3 +103,843 @@ func TestAPI_CompletedMissions(t *testing.T) {
 
 	api.DeleteKey(key)
 }
+
+func TestAPI_FailTimedOutStages(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-timeout")
+
+	plan := model.Plan{Name: "test-plan-timeout", Stages: []*model.Stage{
+		{Name: "stage-1", Timeout: "1ms"},
+		{Name: "stage-2", Upstream: []string{"stage-1"}},
+	}}
+	err := api.SavePlan(key, plan, "")
+	if err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+	missionId, err := api.CreateMissionFromPlan(key, "test-plan-timeout", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start a mission")
+	}
+
+	api.UpdateStageState(key, missionId, "stage-1", "started", false)
+	time.Sleep(5 * time.Millisecond)
+	api.FailTimedOutStages()
+
+	_, err = api.UpdateStageState(key, missionId, "stage-1", "finished", false)
+	if err == nil {
+		t.Fatalf("Stage that timed out should not be able to finish")
+	}
+	_, err = api.UpdateStageState(key, missionId, "stage-1", "started", false)
+	if err != nil {
+		t.Fatalf("Stage that timed out should be able to start again: %v", err)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_SubPlanStage(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-sub-plan")
+
+	child := model.Plan{Name: "test-plan-child", Stages: []*model.Stage{{Name: "child-stage"}}}
+	parent := model.Plan{Name: "test-plan-parent", Stages: []*model.Stage{
+		{Name: "run-child", Service: "plan:test-plan-child", Params: map[string]interface{}{"foo": "bar", "env": "{{ params.env }}"}},
+		{Name: "after-child", Upstream: []string{"run-child"}},
+	}}
+	if err := api.SavePlan(key, child, ""); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+	if err := api.SavePlan(key, parent, ""); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+	cyclic := model.Plan{Name: "test-plan-child", Stages: []*model.Stage{{Name: "run-parent", Service: "plan:test-plan-parent"}}}
+	if err := api.SavePlan(key, cyclic, ""); err == nil {
+		t.Fatalf("Plan that would run itself through another plan should not be saved")
+	}
+	missionId, err := api.CreateMissionFromPlan(key, "test-plan-parent", "", map[string]interface{}{"env": "prod"})
+	if err != nil {
+		t.Fatalf("Failed to start a mission: %v", err)
+	}
+
+	if _, err := api.UpdateStageState(key, missionId, "run-child", "started", false); err != nil {
+		t.Fatalf("Failed to start sub-plan stage: %v", err)
+	}
+	parentString, _ := api.db.Get(key, missionId)
+	m, _ := mission.NewFromJSON([]byte(parentString))
+	s, _ := m.GetStage("run-child")
+	if s.Child == "" {
+		t.Fatalf("Sub-plan stage should be linked to its child mission")
+	}
+	childString, _ := api.db.Get(key, s.Child)
+	c, _ := mission.NewFromJSON([]byte(childString))
+	if c.Parent != missionId || c.ParentStage != "run-child" || c.Params["foo"] != "bar" {
+		t.Fatalf("Child mission should be linked to its parent stage and given its params")
+	}
+	if c.Params["env"] != "prod" {
+		t.Fatalf("Child mission should be given the parent stage's params with templates rendered, got %v", c.Params["env"])
+	}
+
+	api.UpdateStageState(key, c.Id, "child-stage", "started", false)
+	res, err := api.UpdateStageState(key, c.Id, "child-stage", "finished", false)
+	if err != nil || !res.IsComplete {
+		t.Fatalf("Child mission should be complete: %v", err)
+	}
+
+	_, err = api.UpdateStageState(key, missionId, "after-child", "started", false)
+	if err != nil {
+		t.Fatalf("Sub-plan stage should be finished once its child mission is complete: %v", err)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_SubPlanStage_Dispatch(t *testing.T) {
+
+	api := New("")
+	api.config.Dispatcher = DispatcherConfig{Enabled: true, MaxAttempts: 1, Delay: time.Millisecond, Timeout: time.Second}
+	key, _ := api.CreateKey("", "test-sub-plan-dispatch")
+
+	payloads := make(chan model.TriggerMessage, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload model.TriggerMessage
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer server.Close()
+
+	child := model.Plan{
+		Name:     "test-plan-dispatch-child",
+		Services: []model.Service{{Name: "loader", Trigger: map[string]interface{}{"method": "http", "url": server.URL}}},
+		Stages:   []*model.Stage{{Name: "load", Service: "loader"}},
+	}
+	parent := model.Plan{Name: "test-plan-dispatch-parent", Stages: []*model.Stage{
+		{Name: "run-child", Service: "plan:test-plan-dispatch-child"},
+	}}
+	api.SavePlan(key, child, "")
+	api.SavePlan(key, parent, "")
+	missionId, _ := api.CreateMissionFromPlan(key, "test-plan-dispatch-parent", "", nil)
+
+	// sub-plan stages are run by the API, so can't be leased by a worker
+	res, err := api.LeaseStage(key, model.WorkLeaseRequest{Worker: "worker-1", Services: []string{"plan:test-plan-dispatch-child"}})
+	if err != nil || res.Lease != nil {
+		t.Fatalf("Sub-plan stages should not be leased, got %+v: %v", res.Lease, err)
+	}
+
+	api.UpdateStageState(key, missionId, "run-child", "started", false)
+	var payload model.TriggerMessage
+	select {
+	case payload = <-payloads:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The first stage of the child mission should have been dispatched")
+	}
+
+	// wait for the dispatch to be recorded before reading the mission
+	api.dispatching.Wait()
+	parentString, _ := api.db.Get(key, missionId)
+	m, _ := mission.NewFromJSON([]byte(parentString))
+	s, _ := m.GetStage("run-child")
+	if payload.Plan != "test-plan-dispatch-child" || payload.MissionId != s.Child || payload.Stage != "load" {
+		t.Fatalf("Unexpected trigger payload: %v", payload)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_UpdateStage_Outputs(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-outputs")
+
+	plan := model.Plan{Name: "test-plan-outputs", Stages: []*model.Stage{
+		{Name: "extract"},
+		{Name: "load", Upstream: []string{"extract"}, Params: map[string]interface{}{"rows": "{{ stages.extract.outputs.row_count }}"}},
+	}}
+	if err := api.SavePlan(key, plan, ""); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+	missionId, err := api.CreateMissionFromPlan(key, "test-plan-outputs", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start a mission: %v", err)
+	}
+
+	api.UpdateStageState(key, missionId, "extract", "started", false)
+	_, err = api.UpdateStage(key, missionId, "extract", model.MissionStageStateUpdate{State: "finished", Outputs: map[string]interface{}{"row_count": "42"}})
+	if err != nil {
+		t.Fatalf("Failed to finish stage with outputs: %v", err)
+	}
+	res, err := api.UpdateStageState(key, missionId, "load", "started", false)
+	if err != nil || res.Params["rows"] != "42" {
+		t.Fatalf("Started stage should be given params rendered with upstream outputs, got %v: %v", res.Params, err)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_CreateMissionFromPlan_MergesParams(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-params")
+
+	invalid := model.Plan{Name: "test-plan-invalid-params", Stages: []*model.Stage{
+		{Name: "load", Params: map[string]interface{}{"date": "{{ mission.date }}"}},
+	}}
+	if err := api.SavePlan(key, invalid, ""); err == nil {
+		t.Fatalf("Plan with an invalid template should not be saved")
+	}
+
+	plan := model.Plan{Name: "test-plan-params", Params: map[string]interface{}{"env": "dev", "bucket": "data-{{ mission.id }}"}, Stages: []*model.Stage{
+		{Name: "load", Params: map[string]interface{}{"path": "{{ params.bucket }}/{{ params.env }}", "table": "sales"}},
+	}}
+	if err := api.SavePlan(key, plan, ""); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+	missionId, err := api.CreateMissionFromPlan(key, "test-plan-params", "m-params", map[string]interface{}{"env": "prod", "table": "ignored"})
+	if err != nil {
+		t.Fatalf("Failed to start a mission: %v", err)
+	}
+
+	res, err := api.UpdateStageState(key, missionId, "load", "started", false)
+	if err != nil {
+		t.Fatalf("Failed to start stage: %v", err)
+	}
+	if res.Params["path"] != "data-m-params/prod" || res.Params["table"] != "sales" {
+		t.Fatalf("Params should be merged in the order plan < mission < stage, got %v", res.Params)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_UpdateStage_Reset(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-reset")
+
+	planBytes, _ := os.ReadFile("../tests/test_plan.json")
+	var plan model.Plan
+	json.Unmarshal(planBytes, &plan)
+	api.SavePlan(key, plan, "")
+	missionId, err := api.CreateMissionFromPlan(key, "test-plan", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start a mission")
+	}
+
+	api.UpdateStageState(key, missionId, "stage-1", "started", false)
+	api.UpdateStageState(key, missionId, "stage-1", "finished", false)
+	api.UpdateStageState(key, missionId, "stage-2", "skipped", false)
+	if completed := api.CompletedMissions(key); len(completed) != 1 {
+		t.Fatalf("Completed mission is not listed as a completed mission.")
+	}
+
+	res, err := api.UpdateStageState(key, missionId, "stage-1", "reset", false)
+	if err != nil || len(res.Next) != 1 || res.Next[0] != "stage-1" {
+		t.Fatalf("Failed to reset stage: %v", err)
+	}
+	if completed := api.CompletedMissions(key); len(completed) != 0 {
+		t.Fatalf("Reopened mission is still listed as a completed mission.")
+	}
+	if _, err := api.UpdateStageState(key, missionId, "stage-1", "started", false); err != nil {
+		t.Fatalf("Reset stage should be able to start again: %v", err)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_PauseResumeCancelMission(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-cancel")
+
+	planBytes, _ := os.ReadFile("../tests/test_plan.json")
+	var plan model.Plan
+	json.Unmarshal(planBytes, &plan)
+	api.SavePlan(key, plan, "")
+	missionId, err := api.CreateMissionFromPlan(key, "test-plan", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start a mission")
+	}
+
+	if _, err := api.PauseMission(key, missionId); err != nil {
+		t.Fatalf("Failed to pause mission: %v", err)
+	}
+	if _, err := api.UpdateStageState(key, missionId, "stage-1", "started", false); err == nil {
+		t.Fatalf("Stage should not be able to start while the mission is paused")
+	}
+	res, err := api.ResumeMission(key, missionId)
+	if err != nil || len(res.Next) != 1 || res.Next[0] != "stage-1" {
+		t.Fatalf("Failed to resume mission: %v", err)
+	}
+
+	if _, err := api.CancelMission(key, missionId, "no longer needed", "tester"); err != nil {
+		t.Fatalf("Failed to cancel mission: %v", err)
+	}
+	if completed := api.CompletedMissions(key); len(completed) != 1 {
+		t.Fatalf("Cancelled mission is not listed as a completed mission.")
+	}
+	missionString, _ := api.db.Get(key, missionId)
+	m, _ := mission.NewFromJSON([]byte(missionString))
+	if m.Cancellation == nil || m.Cancellation.Actor != "tester" {
+		t.Fatalf("Cancellation should be stored with the mission.")
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_UpdateStage_History(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-history")
+
+	planBytes, _ := os.ReadFile("../tests/test_plan.json")
+	var plan model.Plan
+	json.Unmarshal(planBytes, &plan)
+	api.SavePlan(key, plan, "")
+	missionId, err := api.CreateMissionFromPlan(key, "test-plan", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start a mission")
+	}
+
+	api.UpdateStage(key, missionId, "stage-1", model.MissionStageStateUpdate{State: "started", Caller: "worker-1"})
+	api.UpdateStage(key, missionId, "stage-1", model.MissionStageStateUpdate{State: "failed", Caller: "worker-1", Message: "out of memory"})
+
+	missionString, _ := api.db.Get(key, missionId)
+	m, _ := mission.NewFromJSON([]byte(missionString))
+	s, _ := m.GetStage("stage-1")
+	if len(s.History) != 2 {
+		t.Fatalf("Stage history should be stored with the mission, got %v", s.History)
+	}
+	if s.History[1].Caller != "worker-1" || s.History[1].Message != "out of memory" {
+		t.Fatalf("Caller and message should be recorded in the stage's history, got '%v'", s.History[1])
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_UpdateStage_Error(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-error")
+
+	planBytes, _ := os.ReadFile("../tests/test_plan.json")
+	var plan model.Plan
+	json.Unmarshal(planBytes, &plan)
+	plan.Stages[0].Retry = &model.RetryPolicy{MaxAttempts: 3}
+	api.SavePlan(key, plan, "")
+	missionId, err := api.CreateMissionFromPlan(key, "test-plan", "", nil)
+	if err != nil {
+		t.Fatalf("Failed to start a mission")
+	}
+
+	// errors that don't say whether they are retryable leave it to the retry policy
+	api.UpdateStageState(key, missionId, "stage-1", "started", false)
+	stageError := &model.MissionStageError{Message: "connection reset", Type: "Timeout"}
+	res, err := api.UpdateStage(key, missionId, "stage-1", model.MissionStageStateUpdate{State: "failed", Error: stageError})
+	if err != nil {
+		t.Fatalf("Failed to fail stage: %v", err)
+	}
+	if res.Retry == nil || res.Retry.Exhausted {
+		t.Fatalf("Stage that failed with an error should be retried according to its retry policy")
+	}
+
+	api.UpdateStageState(key, missionId, "stage-1", "started", false)
+	retryable := false
+	stageError = &model.MissionStageError{Message: "permission denied", Type: "Forbidden", Retryable: &retryable}
+	res, err = api.UpdateStage(key, missionId, "stage-1", model.MissionStageStateUpdate{State: "failed", Error: stageError})
+	if err != nil {
+		t.Fatalf("Failed to fail stage: %v", err)
+	}
+	if res.Retry == nil || !res.Retry.Exhausted {
+		t.Fatalf("Stage that failed with an error that isn't retryable should not be retried")
+	}
+
+	missionString, _ := api.db.Get(key, missionId)
+	m, _ := mission.NewFromJSON([]byte(missionString))
+	s, _ := m.GetStage("stage-1")
+	if s.Error == nil || s.Error.Type != "Forbidden" {
+		t.Fatalf("Error should be stored with the mission, got %v", s.Error)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_SavePlan_Versions(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-versions")
+
+	planBytes, _ := os.ReadFile("../tests/test_plan.json")
+	var plan model.Plan
+	json.Unmarshal(planBytes, &plan)
+	if err := api.SavePlan(key, plan, "alice"); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+
+	// change a stage, remove a stage, and add a stage
+	plan.Stages[0].Params = map[string]interface{}{"foo": "baz"}
+	plan.Stages = append(plan.Stages[:1], &model.Stage{Name: "stage-3", Upstream: []string{"stage-1"}})
+	if err := api.SavePlan(key, plan, "bob"); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+
+	versions, err := api.PlanVersions(key, "test-plan")
+	if err != nil || len(versions) != 2 || versions[1].Version != 2 || versions[1].Author != "bob" {
+		t.Fatalf("Both versions of the plan should be listed, got %v", versions)
+	}
+	first, err := api.PlanVersion(key, "test-plan", 1)
+	if err != nil || len(first.Stages) != 2 || first.Version != 1 {
+		t.Fatalf("First version of the plan should be unchanged, got %v", first)
+	}
+
+	diff, err := api.DiffPlanVersions(key, "test-plan", 1, 2)
+	if err != nil {
+		t.Fatalf("Failed to compare plan versions: %v", err)
+	}
+	if len(diff.Added) != 1 || diff.Added[0] != "stage-3" || len(diff.Removed) != 1 || diff.Removed[0] != "stage-2" {
+		t.Fatalf("Added and removed stages are incorrect, got %+v", diff)
+	}
+	if len(diff.Changed) != 1 || diff.Changed[0].Name != "stage-1" || diff.Changed[0].Attributes[0] != "params" {
+		t.Fatalf("Changed stages are incorrect, got %+v", diff.Changed)
+	}
+
+	missionId, _ := api.CreateMissionFromPlan(key, "test-plan", "", nil)
+	missionString, _ := api.db.Get(key, missionId)
+	m, _ := mission.NewFromJSON([]byte(missionString))
+	if m.PlanVersion != 2 {
+		t.Fatalf("Mission should record the version of the plan it was created from, got %v", m.PlanVersion)
+	}
+
+	// versions are kept when the plan is deleted, and carry on from the last version when it's saved again
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodDelete, "/api/v1/plans/test-plan", nil)
+	req.Header.Set("x-access-key", key)
+	api.router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Failed to delete plan: %v", w.Body.String())
+	}
+	if versions, _ := api.PlanVersions(key, "test-plan"); len(versions) != 2 {
+		t.Fatalf("Versions should be kept when the plan is deleted, got %v", versions)
+	}
+	if version, err := api.SavePlanIfMatch(key, plan, "carol", ""); err != nil || version != 3 {
+		t.Fatalf("Plan saved after being deleted should be version 3, got %v: %v", version, err)
+	}
+	if first, _ := api.PlanVersion(key, "test-plan", 1); len(first.Stages) != 2 || first.Version != 1 {
+		t.Fatalf("First version of the plan should not be overwritten, got %v", first)
+	}
+
+	// the diff compares the latest two versions by default
+	w = httptest.NewRecorder()
+	req = httptest.NewRequest(http.MethodGet, "/api/v1/plans/test-plan/diff", nil)
+	req.Header.Set("x-access-key", key)
+	api.router.ServeHTTP(w, req)
+	json.Unmarshal(w.Body.Bytes(), &diff)
+	if w.Code != http.StatusOK || diff.From != 2 || diff.To != 3 {
+		t.Fatalf("Diff should compare the latest two versions by default, got %v: %v", w.Code, w.Body.String())
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_SavePlanIfMatch(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-if-match")
+
+	planBytes, _ := os.ReadFile("../tests/test_plan.json")
+	var plan model.Plan
+	json.Unmarshal(planBytes, &plan)
+
+	if _, err := api.SavePlanIfMatch(key, plan, "", "*"); err == nil {
+		t.Fatalf("Plan that doesn't exist should not match '*'")
+	}
+	version, err := api.SavePlanIfMatch(key, plan, "alice", "")
+	if err != nil || version != 1 {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+
+	// both users have seen version 1, but only the first to save can overwrite it
+	etag := planETag(version)
+	if version, err = api.SavePlanIfMatch(key, plan, "alice", etag); err != nil || version != 2 {
+		t.Fatalf("Plan should be saved when the ETag matches: %v", err)
+	}
+	_, err = api.SavePlanIfMatch(key, plan, "bob", etag)
+	if _, ok := err.(*model.PreconditionFailedError); !ok {
+		t.Fatalf("Plan should not be saved when the ETag is stale, got %v", err)
+	}
+	if versions, _ := api.PlanVersions(key, "test-plan"); len(versions) != 2 {
+		t.Fatalf("Rejected plan should not create a new version, got %v", versions)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_ValidationErrors(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-validation")
+
+	// every problem with the plan is returned, not just the first
+	plan := model.Plan{Name: "test-plan-problems", Stages: []*model.Stage{
+		{Name: "a", Upstream: []string{"missing"}},
+		{Name: "a"},
+	}}
+	err := api.SavePlan(key, plan, "")
+	w := httptest.NewRecorder()
+	handleError(err, w)
+	var res model.ValidationError
+	if e := json.Unmarshal(w.Body.Bytes(), &res); e != nil || w.Code != 400 {
+		t.Fatalf("Expected a validation error response, got %v: %v", w.Code, w.Body.String())
+	}
+	if len(res.Problems) < 2 || res.Problems[0].Code != "duplicate_stage" || res.Problems[1].Path != "stages[0].upstream[0]" {
+		t.Fatalf("Expected every problem to be listed, got %v", res.Problems)
+	}
+
+	// JSON errors give the location of the problem
+	_, err = api.CreateMissionFromPlan(key, `{"name": "test-plan-json", "stages": "a"}`, "", nil)
+	requestError, ok := err.(*model.InvalidRequestError)
+	if !ok || requestError.Problems[0].Code != "invalid_type" || requestError.Problems[0].Path != "stages" {
+		t.Fatalf("Expected an invalid_type problem at 'stages', got %v", err)
+	}
+	_, err = api.CreateMissionFromPlan(key, `{"name": "test-plan-json", "stages": [}`, "", nil)
+	if _, ok := err.(*model.InvalidRequestError); !ok {
+		t.Fatalf("Expected an invalid request error for invalid JSON, got %v", err)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_Graph(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-graph")
+
+	plan := model.Plan{Name: "test-plan-graph", Stages: []*model.Stage{
+		{Name: "extract"},
+		{Name: "load", Upstream: []string{"extract"}},
+	}}
+	if err := api.SavePlan(key, plan, ""); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+	graph, err := api.PlanGraph(key, "test-plan-graph", "dot")
+	if err != nil || !strings.Contains(graph, `"extract" -> "load";`) || strings.Contains(graph, "tooltip") {
+		t.Fatalf("Plan graph should contain every link without stage states, got %v: %v", err, graph)
+	}
+	if _, err := api.PlanGraph(key, "missing", "dot"); err == nil {
+		t.Fatalf("Graph of a plan that doesn't exist should return an error")
+	}
+
+	missionId, _ := api.CreateMissionFromPlan(key, "test-plan-graph", "", nil)
+	api.UpdateStageState(key, missionId, "extract", "started", false)
+	graph, err = api.MissionGraph(key, missionId, "mermaid")
+	if err != nil || !strings.Contains(graph, "class s0 started") {
+		t.Fatalf("Mission graph should be coloured by stage state, got %v: %v", err, graph)
+	}
+	if _, err := api.MissionGraph(key, "missing", "dot"); model.ErrorCode(err) != http.StatusNotFound {
+		t.Fatalf("Graph of a mission that doesn't exist should return a not found error, got %v", err)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_DryRun(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-dry-run")
+
+	plan := model.Plan{Name: "test-plan-dry-run", Stages: []*model.Stage{
+		{Name: "extract"},
+		{Name: "load", Upstream: []string{"extract"}},
+		{Name: "report", Upstream: []string{"extract"}},
+	}}
+	if err := api.SavePlan(key, plan, ""); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+
+	// complete a mission so that stage durations are known
+	missionId, _ := api.CreateMissionFromPlan(key, "test-plan-dry-run", "", nil)
+	for _, s := range []string{"extract", "load", "report"} {
+		api.UpdateStageState(key, missionId, s, "started", false)
+		api.UpdateStageState(key, missionId, s, "finished", false)
+	}
+
+	res, err := api.DryRun(key, "test-plan-dry-run", model.PlanDryRunRequest{Exclude: []string{"report"}})
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	if len(res.Waves) != 2 || res.Waves[1][0] != "load" || len(res.Excluded) != 1 {
+		t.Fatalf("Expected load to run after extract with report excluded, got %v, excluded %v", res.Waves, res.Excluded)
+	}
+	if _, ok := res.Durations["load"]; !ok {
+		t.Fatalf("Durations should be estimated from completed missions, got %v", res.Durations)
+	}
+	if missions := api.ActiveMissions(key, "test-plan-dry-run"); len(missions) != 1 {
+		t.Fatalf("Dry runs should not create missions, got %v", missions)
+	}
+	if _, err := api.DryRun(key, "test-plan-dry-run", model.PlanDryRunRequest{Skip: []string{"missing"}}); err == nil {
+		t.Fatalf("Dry run with a stage that doesn't exist should return an error")
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_SavePlan_Layout(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-layout")
+
+	x, y := 1000, 500
+	plan := model.Plan{Name: "test-plan-layout", Stages: []*model.Stage{
+		{Name: "extract"},
+		{Name: "load", Upstream: []string{"extract"}},
+		{Name: "report", Upstream: []string{"load"}, X: &x, Y: &y},
+	}}
+	if err := api.SavePlan(key, plan, ""); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+
+	saved, _ := api.savedPlan(key, "test-plan-layout")
+	if len(saved.Layout) != 3 || saved.Layout["load"].X <= saved.Layout["extract"].X || saved.Layout["report"] != (mission.Position{X: x, Y: y}) {
+		t.Fatalf("Layout should be computed when the plan is saved, with pinned positions kept, got %v", saved.Layout)
+	}
+
+	missionId, _ := api.CreateMissionFromPlan(key, "test-plan-layout", "", nil)
+	missionString, _ := api.db.Get(key, missionId)
+	m, _ := mission.NewFromJSON([]byte(missionString))
+	for _, s := range m.Stages {
+		if (mission.Position{X: s.X, Y: s.Y}) != saved.Layout[s.Name] {
+			t.Fatalf("Stage '%v' should be positioned according to the plan's layout, got %v, %v", s.Name, s.X, s.Y)
+		}
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_Dispatcher(t *testing.T) {
+
+	api := New("")
+	api.config.Dispatcher = DispatcherConfig{Enabled: true, MaxAttempts: 3, Delay: time.Millisecond, Timeout: time.Second}
+	key, _ := api.CreateKey("", "test-dispatcher")
+
+	// the first request to the flaky service fails, and every request to the broken service fails
+	payloads := make(chan model.TriggerMessage, 10)
+	var requests int32
+	flaky := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		var payload model.TriggerMessage
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer flaky.Close()
+	broken := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer broken.Close()
+
+	plan := model.Plan{
+		Name: "test-plan-dispatcher",
+		Services: []model.Service{
+			{Name: "flaky", Trigger: map[string]interface{}{"method": "http", "url": flaky.URL}},
+			{Name: "broken", Trigger: map[string]interface{}{"method": "http", "url": broken.URL}},
+			{Name: "pubsub", Trigger: map[string]interface{}{"method": "pubsub", "topic": "topic"}},
+			{Name: "secure", Trigger: map[string]interface{}{"method": "http", "url": flaky.URL, "auth": "secret"}},
+		},
+		Stages: []*model.Stage{
+			{Name: "extract", Service: "pubsub"},
+			{Name: "load", Service: "flaky", Upstream: []string{"extract"}},
+			{Name: "report", Service: "broken", Upstream: []string{"extract"}},
+			{Name: "publish", Service: "secure", Upstream: []string{"extract"}},
+		},
+	}
+
+	// the dispatcher can't trigger services that require auth, so plans that use them can't be saved
+	err := api.SavePlan(key, plan, "")
+	var validationErr *mission.PlanValidationError
+	if !errors.As(err, &validationErr) || validationErr.Problems[0].Code != "dispatch_auth" {
+		t.Fatalf("Saving a plan with an auth service should fail while the dispatcher is enabled, got %v", err)
+	}
+
+	// plans saved before the dispatcher was enabled can still use them
+	api.config.Dispatcher.Enabled = false
+	api.SavePlan(key, plan, "")
+	api.config.Dispatcher.Enabled = true
+	missionId, _ := api.CreateMissionFromPlan(key, "test-plan-dispatcher", "", nil)
+	api.UpdateStageState(key, missionId, "extract", "started", false)
+	api.UpdateStageState(key, missionId, "extract", "finished", false)
+
+	select {
+	case payload := <-payloads:
+		if payload.Plan != "test-plan-dispatcher" || payload.MissionId != missionId || payload.Stage != "load" {
+			t.Fatalf("Unexpected trigger payload: %v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The flaky service should have been triggered after being retried")
+	}
+
+	// wait for both dispatches to finish before reading the mission they were recorded on
+	api.dispatching.Wait()
+	missionString, _ := api.db.Get(key, missionId)
+	m, _ := mission.NewFromJSON([]byte(missionString))
+	load, _ := m.GetStage("load")
+	if load.Dispatch == nil || load.Dispatch.Status != mission.DispatchDelivered || load.Dispatch.Attempts != 2 {
+		t.Fatalf("Dispatch of load should be delivered on the second attempt, got %+v", load.Dispatch)
+	}
+	report, _ := m.GetStage("report")
+	if report.Dispatch == nil || report.Dispatch.Status != mission.DispatchFailed || report.Dispatch.Attempts != 3 {
+		t.Fatalf("Dispatch of report should fail after 3 attempts, got %+v", report.Dispatch)
+	}
+	if !strings.Contains(m.Report(), "dispatch failed after 3 attempts") {
+		t.Fatalf("Failed dispatches should be shown in the mission report, got:\n%v", m.Report())
+	}
+	publish, _ := m.GetStage("publish")
+	if publish.Dispatch == nil || publish.Dispatch.Status != mission.DispatchSkipped || publish.Dispatch.Attempts != 0 {
+		t.Fatalf("Dispatch of publish should be skipped because its service requires auth, got %+v", publish.Dispatch)
+	}
+	if !strings.Contains(m.Report(), "dispatch skipped") {
+		t.Fatalf("Skipped dispatches should be shown in the mission report, got:\n%v", m.Report())
+	}
+	if extract, _ := m.GetStage("extract"); extract.Dispatch != nil {
+		t.Fatalf("Services that aren't triggered by HTTP should not be dispatched")
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_DispatchRetries(t *testing.T) {
+
+	api := New("")
+	api.config.Dispatcher = DispatcherConfig{Enabled: true, MaxAttempts: 1, Delay: time.Millisecond, Timeout: time.Second}
+	key, _ := api.CreateKey("", "test-dispatch-retries")
+
+	payloads := make(chan model.TriggerMessage, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload model.TriggerMessage
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads <- payload
+	}))
+	defer server.Close()
+
+	plan := model.Plan{
+		Name:     "test-plan-dispatch-retries",
+		Services: []model.Service{{Name: "loader", Trigger: map[string]interface{}{"method": "http", "url": server.URL}}},
+		Stages: []*model.Stage{
+			{Name: "extract", Service: "loader", Timeout: "1ms", Retry: &model.RetryPolicy{MaxAttempts: 3}},
+			{Name: "load", Service: "loader", Upstream: []string{"extract"}, Retry: &model.RetryPolicy{MaxAttempts: 3, Delay: "200ms"}},
+		},
+	}
+	if err := api.SavePlan(key, plan, ""); err != nil {
+		t.Fatalf("Failed to save plan: %v", err)
+	}
+	missionId, _ := api.CreateMissionFromPlan(key, "test-plan-dispatch-retries", "", nil)
+
+	// stages that time out and can be retried straight away are dispatched by the timeout check
+	api.UpdateStageState(key, missionId, "extract", "started", false)
+	time.Sleep(5 * time.Millisecond)
+	api.FailTimedOutStages()
+	select {
+	case payload := <-payloads:
+		if payload.Stage != "extract" {
+			t.Fatalf("Unexpected trigger payload: %v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The stage that timed out should have been dispatched to be retried")
+	}
+	api.UpdateStageState(key, missionId, "extract", "started", false)
+	api.UpdateStageState(key, missionId, "extract", "finished", false)
+	if payload := <-payloads; payload.Stage != "load" {
+		t.Fatalf("Unexpected trigger payload: %v", payload)
+	}
+
+	api.UpdateStageState(key, missionId, "load", "started", false)
+	api.UpdateStageState(key, missionId, "load", "failed", false)
+	api.dispatching.Wait()
+	api.DispatchRetries()
+	api.dispatching.Wait()
+	if len(payloads) != 0 {
+		t.Fatalf("Stage should not be dispatched until its retry delay has passed, got %v", <-payloads)
+	}
+
+	time.Sleep(250 * time.Millisecond)
+	api.DispatchRetries()
+	select {
+	case payload := <-payloads:
+		if payload.MissionId != missionId || payload.Stage != "load" {
+			t.Fatalf("Unexpected trigger payload: %v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("The failed stage should have been dispatched once its retry delay passed")
+	}
+	api.dispatching.Wait()
+
+	// the retry has already been dispatched, so it isn't dispatched again
+	api.DispatchRetries()
+	api.dispatching.Wait()
+	if len(payloads) != 0 {
+		t.Fatalf("Stage should only be dispatched once per retry, got %v", <-payloads)
+	}
+
+	api.DeleteKey(key)
+}
+
+func TestAPI_WorkLease(t *testing.T) {
+
+	api := New("")
+	key, _ := api.CreateKey("", "test-work-lease")
+
+	plan := model.Plan{Name: "test-plan-work-lease", Params: map[string]interface{}{"table": "sales"}, Stages: []*model.Stage{
+		{Name: "extract", Service: "extractor"},
+		{Name: "load", Service: "loader", Upstream: []string{"extract"}},
+	}}
+	api.SavePlan(key, plan, "")
+	missionId, _ := api.CreateMissionFromPlan(key, "test-plan-work-lease", "", nil)
+
+	if res, err := api.LeaseStage(key, model.WorkLeaseRequest{Worker: "worker-1", Services: []string{"loader"}}); err != nil || res.Lease != nil {
+		t.Fatalf("No stages should be leased when none of the worker's services are ready, got %v: %v", err, res.Lease)
+	}
+	res, err := api.LeaseStage(key, model.WorkLeaseRequest{Worker: "worker-1", Services: []string{"extractor", "loader"}, Duration: "1ms"})
+	if err != nil || res.Lease == nil || res.Lease.MissionId != missionId || res.Lease.Stage != "extract" || res.Lease.Params["table"] != "sales" {
+		t.Fatalf("Expected extract to be leased with its params, got %v: %+v", err, res.Lease)
+	}
+	if res, _ := api.LeaseStage(key, model.WorkLeaseRequest{Worker: "worker-2", Services: []string{"extractor"}}); res.Lease != nil {
+		t.Fatalf("Stages should only be leased to one worker, got %+v", res.Lease)
+	}
+
+	time.Sleep(5 * time.Millisecond)
+	api.ReleaseExpiredLeases()
+	if _, err := api.RenewLease(key, model.WorkHeartbeatRequest{Worker: "worker-1", MissionId: missionId, Stage: "extract"}); err == nil {
+		t.Fatalf("Expired leases should not be renewed")
+	}
+
+	res, _ = api.LeaseStage(key, model.WorkLeaseRequest{Worker: "worker-2", Services: []string{"extractor"}})
+	if res.Lease == nil || res.Lease.Stage != "extract" {
+		t.Fatalf("Stages with expired leases should be leased again, got %+v", res.Lease)
+	}
+	renewed, err := api.RenewLease(key, model.WorkHeartbeatRequest{Worker: "worker-2", MissionId: missionId, Stage: "extract", Duration: "1h"})
+	if err != nil || !renewed.Lease.Expiry.After(res.Lease.Expiry) {
+		t.Fatalf("Failed to renew lease: %v", err)
+	}
+	if _, err := api.UpdateStage(key, missionId, "extract", model.MissionStageStateUpdate{State: "finished", Worker: "worker-1"}); err == nil {
+		t.Fatalf("Workers should not be able to finish stages that have been leased to another worker")
+	}
+	if _, err := api.UpdateStage(key, missionId, "extract", model.MissionStageStateUpdate{State: "failed", Worker: "worker-1"}); err == nil {
+		t.Fatalf("Workers should not be able to fail stages that have been leased to another worker")
+	}
+	if _, err := api.UpdateStage(key, missionId, "extract", model.MissionStageStateUpdate{State: "finished", Worker: "worker-2"}); err != nil {
+		t.Fatalf("The worker that holds the lease should be able to finish the stage: %v", err)
+	}
+
+	res, _ = api.LeaseStage(key, model.WorkLeaseRequest{Worker: "worker-1", Services: []string{"loader"}})
+	if res.Lease == nil || res.Lease.Stage != "load" {
+		t.Fatalf("Stages should be leased once their upstream stages have finished, got %+v", res.Lease)
+	}
+	if _, err := api.UpdateStage(key, missionId, "load", model.MissionStageStateUpdate{State: "failed", Caller: "operator"}); err != nil {
+		t.Fatalf("Callers that aren't workers should be able to fail leased stages: %v", err)
+	}
+	if _, err := api.LeaseStage(key, model.WorkLeaseRequest{Worker: "worker-1", Services: []string{"loader"}, Duration: "soon"}); err == nil {
+		t.Fatalf("Invalid lease durations should return an error")
+	}
+
+	api.DeleteKey(key)
+}
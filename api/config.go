@@ -7,14 +7,17 @@ import (
 )
 
 type Config struct {
-	Port           string          `yaml:"port" env:"HOUSTON_PORT" env-default:"8000" json:"port"`
-	Redis          RedisConfig     `yaml:"redis" json:"redis"`
-	Password       string          `yaml:"password" env:"HOUSTON_PASSWORD" json:"password"`
-	Dashboard      DashboardConfig `yaml:"dashboard" json:"dashboard"`
-	TLS            TLSConfig       `yaml:"tls" json:"tls"`
-	MissionExpiry  time.Duration   `yaml:"mission_expiry" env:"HOUSTON_MISSION_EXPIRY" env-default:"720h"`     // 30 days
-	MemoryLimitMiB int64           `yaml:"memory_limit_mib" env:"HOUSTON_MEMORY_LIMIT_MIB" env-default:"3072"` // 3GiB
-	Salt           string          `json:"-"`                                                                  // note: it is not recommended to set the salt yourself. It will be randomly generated
+	Port           string           `yaml:"port" env:"HOUSTON_PORT" env-default:"8000" json:"port"`
+	Redis          RedisConfig      `yaml:"redis" json:"redis"`
+	Password       string           `yaml:"password" env:"HOUSTON_PASSWORD" json:"password"`
+	Dashboard      DashboardConfig  `yaml:"dashboard" json:"dashboard"`
+	Dispatcher     DispatcherConfig `yaml:"dispatcher" json:"dispatcher"`
+	TLS            TLSConfig        `yaml:"tls" json:"tls"`
+	MissionExpiry  time.Duration    `yaml:"mission_expiry" env:"HOUSTON_MISSION_EXPIRY" env-default:"720h"`     // 30 days
+	MemoryLimitMiB int64            `yaml:"memory_limit_mib" env:"HOUSTON_MEMORY_LIMIT_MIB" env-default:"3072"` // 3GiB
+	TimeoutCheck   time.Duration    `yaml:"timeout_check" env:"HOUSTON_TIMEOUT_CHECK" env-default:"10s"`        // how often to look for timed out stages
+	LeaseDuration  time.Duration    `yaml:"lease_duration" env:"HOUSTON_LEASE_DURATION" env-default:"5m"`       // how long workers can run stages without a heartbeat
+	Salt           string           `json:"-"`                                                                  // note: it is not recommended to set the salt yourself. It will be randomly generated
 }
 
 type DashboardConfig struct {
@@ -22,6 +25,14 @@ type DashboardConfig struct {
 	Src     string `yaml:"src" env:"HOUSTON_DASHBOARD_SRC" env-default:"" json:"src"`
 }
 
+// DispatcherConfig configures how the API triggers the services of stages that are ready to run, see dispatchStages.
+type DispatcherConfig struct {
+	Enabled     bool          `yaml:"enabled" env:"HOUSTON_DISPATCHER" env-default:"false" json:"enabled"`
+	MaxAttempts int           `yaml:"max_attempts" env:"HOUSTON_DISPATCHER_MAX_ATTEMPTS" env-default:"5" json:"maxAttempts"`
+	Delay       time.Duration `yaml:"delay" env:"HOUSTON_DISPATCHER_DELAY" env-default:"1s" json:"delay"` // doubled after every failed attempt
+	Timeout     time.Duration `yaml:"timeout" env:"HOUSTON_DISPATCHER_TIMEOUT" env-default:"10s" json:"timeout"`
+}
+
 type RedisConfig struct {
 	Addr     string `yaml:"addr" env:"REDIS_ADDR" env-default:"localhost:6379" json:"addr"`
 	Password string `yaml:"password" env:"REDIS_PASSWORD" env-default:"" json:"password"`
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/datasparq-ai/houston/mission"
+	"github.com/datasparq-ai/houston/model"
+	"net/http"
+	"time"
+)
+
+// dispatchStages triggers the services of the stages given, which are ready to run, if the dispatcher is enabled. Only
+// services with an HTTP trigger and no auth are triggered by the API; all other services are left to be triggered by
+// the client. Failed stages are only given once their retry delay has passed, see DispatchRetries.
+func (a *API) dispatchStages(key string, stages []string, missionBytes []byte) {
+	if !a.config.Dispatcher.Enabled || len(stages) == 0 {
+		return
+	}
+	m, err := mission.NewFromJSON(missionBytes)
+	if err != nil {
+		return
+	}
+	for _, name := range stages {
+		trigger, err := m.ServiceTrigger(name)
+		if err != nil || trigger["method"] != "http" {
+			continue
+		}
+		if _, ok := trigger["auth"]; ok {
+			// plans with these services can't be saved while the dispatcher is enabled, but may have been saved before
+			keyLog.Warnf("Not dispatching stage %s in mission %s because its service requires auth", name, m.Id)
+			d := mission.Dispatch{Status: mission.DispatchSkipped, Time: time.Now(), Message: "the service requires auth, so it must be triggered by a client"}
+			a.recordDispatch(key, m.Id, name, d)
+			continue
+		}
+		url, _ := trigger["url"].(string)
+		a.dispatching.Add(1)
+		go func(name string) {
+			defer a.dispatching.Done()
+			a.dispatch(key, model.TriggerMessage{Plan: m.Name, MissionId: m.Id, Stage: name}, url)
+		}(name)
+	}
+}
+
+// dispatch sends a stage's trigger to its service, retrying with exponential backoff until the service accepts it or
+// Config.Dispatcher.MaxAttempts is reached. The outcome of every attempt is recorded on the stage so that services that
+// can't be reached show up on the mission.
+func (a *API) dispatch(key string, payload model.TriggerMessage, url string) {
+	body, _ := json.Marshal(payload)
+	client := http.Client{Timeout: a.config.Dispatcher.Timeout}
+
+	// the dispatch is recorded before the first attempt so that DispatchRetries doesn't dispatch the stage again
+	d := mission.Dispatch{Status: mission.DispatchPending, Time: time.Now()}
+	a.recordDispatch(key, payload.MissionId, payload.Stage, d)
+	for {
+		d.Attempts++
+		d.Time = time.Now()
+		d.Status, d.Message = mission.DispatchDelivered, ""
+		if err := postTrigger(&client, url, body); err != nil {
+			keyLog.Warnf("Couldn't dispatch stage %s in mission %s (attempt %v): %v", payload.Stage, payload.MissionId, d.Attempts, err)
+			d.Status, d.Message = mission.DispatchPending, err.Error()
+			if d.Attempts >= a.config.Dispatcher.MaxAttempts {
+				d.Status = mission.DispatchFailed
+			}
+		}
+
+		a.recordDispatch(key, payload.MissionId, payload.Stage, d)
+		if d.Status != mission.DispatchPending {
+			return
+		}
+		time.Sleep(a.config.Dispatcher.Delay * time.Duration(1<<(d.Attempts-1)))
+	}
+}
+
+// recordDispatch stores the outcome of dispatching a stage on its mission, and notifies websocket clients.
+func (a *API) recordDispatch(key string, missionId string, stageName string, d mission.Dispatch) {
+	missionBytes, err := a.updateMission(key, missionId, func(m *mission.Mission) error {
+		return m.SetDispatch(stageName, &d)
+	})
+	if err != nil {
+		keyLog.Errorf("Couldn't record dispatch of stage %s in mission %s: %v", stageName, missionId, err)
+		return
+	}
+	a.ws <- message{key, "missionUpdate", missionBytes}
+}
+
+// postTrigger sends a trigger to an HTTP service. Any response other than 2xx is an error.
+func postTrigger(client *http.Client, url string, body []byte) error {
+	res, err := client.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("service responded with status %v", res.Status)
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package api
+
+import (
+	"time"
+
+	"github.com/datasparq-ai/houston/mission"
+	"github.com/datasparq-ai/houston/model"
+)
+
+// durationHistory is the number of completed missions used to estimate the duration of each stage in a dry run.
+const durationHistory = 20
+
+// DryRun shows what would happen if a mission was created from a saved plan with the options given, without creating
+// the mission. See mission.DryRun for details. Stage durations are estimated from the plan's recent completed missions.
+func (a *API) DryRun(key string, planName string, req model.PlanDryRunRequest) (*mission.ExecutionPlan, error) {
+	plan, err := a.savedPlan(key, planName)
+	if err != nil {
+		return nil, err
+	}
+
+	m := NewMissionFromPlan(&plan)
+	m.Params = mergeParams(plan.Params, req.Params)
+	if err := m.Validate(); err != nil {
+		return nil, err // the params may contain invalid templates
+	}
+	return m.DryRun(req.Stages, req.Exclude, req.Skip, a.stageDurations(key, planName))
+}
+
+// stageDurations returns the average duration of each stage that finished in the plan's most recent completed
+// missions.
+func (a *API) stageDurations(key string, planName string) map[string]time.Duration {
+	totals := make(map[string]time.Duration)
+	counts := make(map[string]int)
+	completed := a.CompletedMissions(key)
+	for i, found := len(completed)-1, 0; i >= 0 && found < durationHistory; i-- {
+		missionString, ok := a.db.Get(key, completed[i])
+		if !ok {
+			continue // the mission has been deleted
+		}
+		m, err := mission.NewFromJSON([]byte(missionString))
+		if err != nil || m.Name != planName {
+			continue
+		}
+		found++
+		for name, d := range m.FinishedDurations() {
+			totals[name] += d
+			counts[name]++
+		}
+	}
+
+	durations := make(map[string]time.Duration, len(totals))
+	for name, total := range totals {
+		durations[name] = (total / time.Duration(counts[name])).Round(time.Second)
+	}
+	return durations
+}
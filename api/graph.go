@@ -0,0 +1,45 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/datasparq-ai/houston/mission"
+	"github.com/datasparq-ai/houston/model"
+)
+
+// graphContentTypes gives the Content-Type header for each format that graphs can be exported in.
+var graphContentTypes = map[string]string{
+	mission.FormatDOT:     "text/vnd.graphviz; charset=utf-8",
+	mission.FormatMermaid: "text/plain; charset=utf-8",
+	mission.FormatSVG:     "image/svg+xml",
+}
+
+// PlanGraph returns the graph of a saved plan in the format given. See mission.Export for the supported formats.
+func (a *API) PlanGraph(key string, planName string, format string) (string, error) {
+	plan, err := a.savedPlan(key, planName)
+	if err != nil {
+		return "", err
+	}
+	return NewMissionFromPlan(&plan).Export(format, false)
+}
+
+// MissionGraph returns the graph of a mission in the format given, with every stage coloured by its state.
+func (a *API) MissionGraph(key string, missionId string, format string) (string, error) {
+	missionString, ok := a.db.Get(key, missionId)
+	if !ok {
+		return "", &model.MissionNotFoundError{MissionId: missionId}
+	}
+	m, err := mission.NewFromJSON([]byte(missionString))
+	if err != nil {
+		return "", err
+	}
+	return m.Export(format, true)
+}
+
+// graphFormat returns the format requested in the 'format' query param, which defaults to SVG.
+func graphFormat(r *http.Request) string {
+	if format := r.URL.Query().Get("format"); format != "" {
+		return format
+	}
+	return mission.FormatSVG
+}
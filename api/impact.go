@@ -0,0 +1,61 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/datasparq-ai/houston/mission"
+	"github.com/datasparq-ai/houston/model"
+)
+
+// PlanRelatedStages returns every stage upstream or downstream of a stage in a saved plan, up to the depth given. A
+// depth of 0 means there is no limit.
+func (a *API) PlanRelatedStages(key string, planName string, stageName string, direction string, depth int) (model.RelatedStagesResponse, error) {
+	plan, err := a.savedPlan(key, planName)
+	if err != nil {
+		return model.RelatedStagesResponse{}, err
+	}
+	return relatedStages(NewMissionFromPlan(&plan), stageName, direction, depth, false)
+}
+
+// MissionRelatedStages returns every stage upstream or downstream of a stage in a mission, up to the depth given,
+// along with the current state of each stage. A depth of 0 means there is no limit.
+func (a *API) MissionRelatedStages(key string, missionId string, stageName string, direction string, depth int) (model.RelatedStagesResponse, error) {
+	missionString, ok := a.db.Get(key, missionId)
+	if !ok {
+		return model.RelatedStagesResponse{}, &model.MissionNotFoundError{MissionId: missionId}
+	}
+	m, err := mission.NewFromJSON([]byte(missionString))
+	if err != nil {
+		return model.RelatedStagesResponse{}, err
+	}
+	return relatedStages(&m, stageName, direction, depth, true)
+}
+
+func relatedStages(m *mission.Mission, stageName string, direction string, depth int, showState bool) (model.RelatedStagesResponse, error) {
+	res := model.RelatedStagesResponse{Stage: stageName, Direction: direction}
+	var err error
+	switch direction {
+	case "upstream":
+		res.Stages, err = m.Upstream(stageName, depth, showState)
+	case "downstream":
+		res.Stages, err = m.Downstream(stageName, depth, showState)
+	default:
+		err = fmt.Errorf("direction must be 'upstream' or 'downstream', not '%v'", direction)
+	}
+	return res, err
+}
+
+// depthParam returns the value of the 'depth' query param, which defaults to 0 (no limit).
+func depthParam(r *http.Request) (int, error) {
+	depth := r.URL.Query().Get("depth")
+	if depth == "" {
+		return 0, nil
+	}
+	d, err := strconv.Atoi(depth)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("depth must be a positive integer, not '%v'", depth)
+	}
+	return d, nil
+}
@@ -0,0 +1,161 @@
+package api
+
+import (
+	"fmt"
+	"github.com/datasparq-ai/houston/mission"
+	"github.com/datasparq-ai/houston/model"
+	"time"
+)
+
+// LeaseStage finds a stage that is ready to run in any active mission, and that is run by one of the services given,
+// then starts it and leases it to the worker. Stages are leased within a transaction, so a stage can only be leased to
+// one worker. Returns a response without a lease if no stages are ready.
+// POST /api/v1/work/lease
+func (a *API) LeaseStage(key string, req model.WorkLeaseRequest) (model.WorkLeaseResponse, error) {
+	duration, err := a.leaseDuration(req.Duration)
+	if err != nil {
+		return model.WorkLeaseResponse{}, err
+	}
+	if len(req.Services) == 0 {
+		return model.WorkLeaseResponse{}, fmt.Errorf("at least one service must be given")
+	}
+	services := make(map[string]bool, len(req.Services))
+	for _, s := range req.Services {
+		services[s] = true
+	}
+
+	plans, err := a.ListPlans(key)
+	if err != nil {
+		return model.WorkLeaseResponse{}, err
+	}
+	for _, planName := range plans {
+		for _, missionId := range a.ActiveMissions(key, planName) {
+			missionString, ok := a.db.Get(key, missionId)
+			if !ok {
+				continue
+			}
+			m, err := mission.NewFromJSON([]byte(missionString))
+			if err != nil || !m.End.IsZero() {
+				continue
+			}
+			for _, stageName := range m.Next() {
+				if s, _ := m.GetStage(stageName); !services[s.Service] {
+					continue
+				}
+				if lease, err := a.leaseStage(key, missionId, stageName, req.Worker, time.Now().Add(duration)); err == nil {
+					return model.WorkLeaseResponse{Lease: lease}, nil
+				}
+				// the stage may have just been started by another worker, or can't be leased, e.g. map or sub-plan stages
+			}
+		}
+	}
+	return model.WorkLeaseResponse{}, nil
+}
+
+// leaseStage starts a stage and leases it to a worker within a transaction.
+func (a *API) leaseStage(key string, missionId string, stageName string, worker string, expiry time.Time) (*model.WorkLease, error) {
+	var res mission.Response
+	var lease *model.WorkLease
+	missionBytes, err := a.updateMission(key, missionId, func(m *mission.Mission) error {
+		var err error
+		res, err = m.LeaseStage(stageName, worker, expiry)
+		if err != nil {
+			return err
+		}
+		s, _ := m.GetStage(stageName)
+		lease = &model.WorkLease{Plan: m.Name, MissionId: m.Id, Stage: s.Name, Service: s.Service, Params: res.Params, Expiry: expiry}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	keyLog.Infof("Stage %s in mission %s has been leased to worker '%s' until %v", stageName, missionId, worker, expiry)
+	a.ws <- message{key, "missionUpdate", missionBytes}
+	return lease, nil
+}
+
+// RenewLease extends the lease of a stage that a worker is running.
+// POST /api/v1/work/heartbeat
+func (a *API) RenewLease(key string, req model.WorkHeartbeatRequest) (model.WorkLeaseResponse, error) {
+	duration, err := a.leaseDuration(req.Duration)
+	if err != nil {
+		return model.WorkLeaseResponse{}, err
+	}
+	expiry := time.Now().Add(duration)
+	var lease *model.WorkLease
+	_, err = a.updateMission(key, req.MissionId, func(m *mission.Mission) error {
+		if err := m.RenewLease(req.Stage, req.Worker, expiry); err != nil {
+			return err
+		}
+		s, _ := m.GetStage(req.Stage)
+		lease = &model.WorkLease{Plan: m.Name, MissionId: m.Id, Stage: s.Name, Service: s.Service, Expiry: expiry}
+		return nil
+	})
+	if err != nil {
+		return model.WorkLeaseResponse{}, err
+	}
+	return model.WorkLeaseResponse{Lease: lease}, nil
+}
+
+// leaseDuration parses the duration of a lease requested by a worker, which defaults to Config.LeaseDuration.
+func (a *API) leaseDuration(requested string) (time.Duration, error) {
+	if requested == "" {
+		return a.config.LeaseDuration, nil
+	}
+	d, err := time.ParseDuration(requested)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("lease duration '%v' is not a valid duration, e.g. '5m'", requested)
+	}
+	return d, nil
+}
+
+// ReleaseExpiredLeases looks at every active mission for every key and returns stages with expired leases to ready,
+// so that they can be leased to another worker, or fails them if they have no attempts left. Missions are only updated
+// within a transaction if they have expired leases, in the same way as FailTimedOutStages.
+func (a *API) ReleaseExpiredLeases() {
+	keys, err := a.db.ListKeys()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for _, key := range keys {
+		plans, err := a.ListPlans(key)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for _, planName := range plans {
+			for _, missionId := range a.ActiveMissions(key, planName) {
+				missionString, ok := a.db.Get(key, missionId)
+				if !ok {
+					continue
+				}
+				m, err := mission.NewFromJSON([]byte(missionString))
+				if err != nil || !m.HasExpiredLeases(time.Now()) {
+					continue
+				}
+				var released, exhausted []string
+				isComplete := false
+				missionBytes, err := a.updateMission(key, missionId, func(m *mission.Mission) error {
+					released, exhausted = m.ReleaseExpiredLeases(time.Now())
+					isComplete = !m.End.IsZero()
+					return nil
+				})
+				if err != nil {
+					// the mission was probably being updated at the same time - it will be checked again soon
+					log.Debugf("Couldn't release expired leases in mission '%v': %v", missionId, err)
+					continue
+				}
+				SetLoggingFile(keyLog, key)
+				for _, stageName := range released {
+					keyLog.Warnf("The lease of stage %s in mission %s has expired and the stage has been set to ready", stageName, missionId)
+				}
+				for _, stageName := range exhausted {
+					keyLog.Warnf("The lease of stage %s in mission %s has expired and the stage has been set to failed because it has no attempts left", stageName, missionId)
+				}
+				a.missionUpdated(key, missionId, isComplete, missionBytes)
+			}
+		}
+	}
+}
@@ -5,11 +5,14 @@ import (
 	"github.com/datasparq-ai/houston/database"
 	"github.com/datasparq-ai/houston/mission"
 	"runtime"
+	"slices"
 	"time"
 )
 
-// Monitor checks the health of the API server and performs other duties at regular intervals
+// Monitor checks the health of the API server and performs other duties at regular intervals.
+// Timed out stages are checked for much more frequently than other duties, so this is done in a separate goroutine.
 func (a *API) Monitor() {
+	go a.MonitorTimeouts()
 	for {
 		a.DeleteExpiredMissions()
 		a.HealthCheck()
@@ -17,6 +20,17 @@ func (a *API) Monitor() {
 	}
 }
 
+// MonitorTimeouts looks for stages and missions that have exceeded their timeout, stages whose lease has expired, and
+// failed stages that are due to be retried, at the interval set by Config.TimeoutCheck.
+func (a *API) MonitorTimeouts() {
+	for {
+		time.Sleep(a.config.TimeoutCheck)
+		a.FailTimedOutStages()
+		a.ReleaseExpiredLeases()
+		a.DispatchRetries()
+	}
+}
+
 func (a *API) HealthCheck() {
 	log.Info("Checking the health of the database")
 	err := a.db.Health()
@@ -132,3 +146,127 @@ func (a *API) missionCanBeDeleted(key string, missionId string) bool {
 	log.Debugf("Mission '%v' won't be deleted because it started under %s ago\n", missionId, a.config.MissionExpiry)
 	return false
 }
+
+// FailTimedOutStages looks at every active mission for every key and fails any stages that are overdue. Missions are
+// only updated within a transaction if they have overdue stages, so that active missions are not locked unnecessarily.
+func (a *API) FailTimedOutStages() {
+	keys, err := a.db.ListKeys()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for _, key := range keys {
+		plans, err := a.ListPlans(key)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for _, planName := range plans {
+			for _, missionId := range a.ActiveMissions(key, planName) {
+				missionString, ok := a.db.Get(key, missionId)
+				if !ok {
+					continue
+				}
+				m, err := mission.NewFromJSON([]byte(missionString))
+				if err != nil || !m.HasOverdueStages(time.Now()) {
+					continue
+				}
+				a.failOverdueStages(key, missionId)
+			}
+		}
+	}
+}
+
+// failOverdueStages fails the overdue stages of a single mission within a transaction and notifies websocket clients.
+// Stages that can run as a result, e.g. stages that are retried without a delay, are dispatched.
+func (a *API) failOverdueStages(key string, missionId string) {
+	var timedOut, next []string
+	var missionBytes []byte
+	isComplete := false
+
+	txnFunc := func(missionString string) (string, error) {
+		m, err := mission.NewFromJSON([]byte(missionString))
+		if err != nil {
+			return "", err
+		}
+		ready := m.Next()
+		timedOut = m.FailOverdueStages(time.Now())
+		isComplete = !m.End.IsZero()
+		next = nil
+		for _, stageName := range m.Next() {
+			if !slices.Contains(ready, stageName) {
+				next = append(next, stageName)
+			}
+		}
+		missionBytes = m.Bytes()
+		return string(missionBytes), nil
+	}
+
+	err := a.db.DoTransaction(txnFunc, key, missionId)
+	if err != nil {
+		// the mission was probably being updated at the same time - it will be checked again soon
+		log.Debugf("Couldn't fail timed out stages in mission '%v': %v", missionId, err)
+		return
+	}
+
+	SetLoggingFile(keyLog, key)
+	for _, stageName := range timedOut {
+		keyLog.Warnf("Stage %s in mission %s has timed out and has been set to failed", stageName, missionId)
+	}
+	a.missionUpdated(key, missionId, isComplete, missionBytes)
+	a.dispatchStages(key, next, missionBytes)
+}
+
+// DispatchRetries looks at every active mission for every key and dispatches the failed stages whose retry delay has
+// passed, if the dispatcher is enabled. These stages would otherwise wait for a client or worker to notice them.
+func (a *API) DispatchRetries() {
+	if !a.config.Dispatcher.Enabled {
+		return
+	}
+	keys, err := a.db.ListKeys()
+	if err != nil {
+		log.Error(err)
+		return
+	}
+
+	for _, key := range keys {
+		plans, err := a.ListPlans(key)
+		if err != nil {
+			log.Error(err)
+			continue
+		}
+		for _, planName := range plans {
+			for _, missionId := range a.ActiveMissions(key, planName) {
+				missionString, ok := a.db.Get(key, missionId)
+				if !ok {
+					continue
+				}
+				m, err := mission.NewFromJSON([]byte(missionString))
+				if err != nil {
+					continue
+				}
+				if due := m.RetriesDue(); len(due) > 0 {
+					SetLoggingFile(keyLog, key)
+					a.dispatchStages(key, due, []byte(missionString))
+				}
+			}
+		}
+	}
+}
+
+// missionUpdated notifies websocket clients of a mission that was updated by one of the API's background checks. If
+// the mission is now complete then it is added to the list of completed missions, and its parent stage is updated if
+// it is a child mission.
+func (a *API) missionUpdated(key string, missionId string, isComplete bool, missionBytes []byte) {
+	a.ws <- message{key, "missionUpdate", missionBytes}
+
+	if isComplete {
+		a.ws <- message{key, "missionCompleted", missionBytes}
+		keyLog.Infof("Mission %s is complete", missionId)
+		if err := a.updateActiveOrCompletedMissions(key, "c", "", []string{missionId}, nil); err != nil {
+			log.Error(err)
+		}
+	}
+	a.updateParentStage(key, missionBytes)
+}
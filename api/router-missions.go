@@ -69,6 +69,30 @@ func (a *API) GetMissionReport(w http.ResponseWriter, r *http.Request) {
 	w.Write(payload)
 }
 
+// GetMissionGraph godoc
+// @Summary Gets the graph of a mission as DOT, Mermaid, or SVG.
+// @Description Returns the mission's graph with every stage coloured by its state, which can be rendered by Graphviz or Mermaid, or embedded as an image.
+// @ID get-mission-graph
+// @Tags Mission
+// @Param x-access-key header string true "Houston Key"
+// @Param id path string true "The id of the mission"
+// @Param format query string false "One of 'dot', 'mermaid', or 'svg' (default)"
+// @Success 200 {string} string
+// @Failure 400,404,500 {object} model.Error
+// @Router /api/v1/missions/{id}/graph [get]
+func (a *API) GetMissionGraph(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+	format := graphFormat(r)
+	graph, err := a.MissionGraph(key, vars["id"], format)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", graphContentTypes[format])
+	w.Write([]byte(graph))
+}
+
 // GetMissions godoc
 // @Summary Gets all existing missions.
 // @Description Returns all existing missions for a given Houston Key.
@@ -98,12 +122,13 @@ func (a *API) GetMissions(w http.ResponseWriter, r *http.Request) {
 // @Param x-access-key header string true "Houston Key"
 // @Param Body body model.MissionCreateRequest true "The plan, ID, and parameters to give to the new mission."
 // @Success 200 {object} model.MissionCreatedResponse
+// @Failure 400 {object} model.ValidationError
 // @Failure 404,500 {object} model.Error
 // @Router /api/v1/missions [post]
 func (a *API) PostMission(w http.ResponseWriter, r *http.Request) {
 	reqBody, _ := io.ReadAll(r.Body)
 	var mission model.MissionCreateRequest
-	err := json.Unmarshal(reqBody, &mission)
+	err := decodeJSON(reqBody, &mission)
 	if err != nil {
 		handleError(err, w)
 		return
@@ -155,7 +180,7 @@ func (a *API) deleteMission(w http.ResponseWriter, r *http.Request) {
 // @ID post-mission-stage
 // @Tags Mission
 // @Param x-access-key header string true "Houston Key"
-// @Param Body body model.MissionStageStateUpdate true "The state of the stage and whether dependencies have been ignored."
+// @Param Body body model.MissionStageStateUpdate true "The state of the stage, whether dependencies have been ignored, and whether a failure is fatal."
 // @Param id path string true "The id of the mission"
 // @Param name path string true "The name of the plan"
 // @Success 200 {object} model.Success
@@ -165,9 +190,8 @@ func (a *API) PostMissionStage(w http.ResponseWriter, r *http.Request) {
 
 	reqBody, _ := io.ReadAll(r.Body)
 	var stage model.MissionStageStateUpdate
-	err := json.Unmarshal(reqBody, &stage)
+	err := decodeJSON(reqBody, &stage)
 	if err != nil {
-		// TODO: helpful error message for json validation errors, e.g. 'state is missing'
 		handleError(err, w)
 		return
 	}
@@ -177,7 +201,8 @@ func (a *API) PostMissionStage(w http.ResponseWriter, r *http.Request) {
 	stageName := vars["name"]
 	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
 
-	res, err := a.UpdateStageState(key, missionId, stageName, stage.State, stage.IgnoreDependencies)
+	stage.Caller = a.callerName(key, r)
+	res, err := a.UpdateStage(key, missionId, stageName, stage)
 	if err != nil {
 		handleError(err, w)
 		return
@@ -194,6 +219,151 @@ func (a *API) PostMissionStage(w http.ResponseWriter, r *http.Request) {
 
 }
 
+// GetMissionStageHistory godoc
+// @Summary Gets the history of a stage in a mission.
+// @Description Returns every change in the state of the stage, oldest first, including who made each change and why.
+// @ID get-mission-stage-history
+// @Tags Mission
+// @Param x-access-key header string true "Houston Key"
+// @Param id path string true "The id of the mission"
+// @Param name path string true "The name of the stage"
+// @Success 200 {array} model.MissionStageTransition
+// @Failure 404,500 {object} model.Error
+// @Router /api/v1/missions/{id}/stages/{name}/history [get]
+func (a *API) GetMissionStageHistory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	missionId := vars["id"]
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+	missionString, ok := a.db.Get(key, missionId)
+	if !ok {
+		err := fmt.Errorf("mission with id '%v' not found", missionId)
+		handleError(err, w)
+		return
+	}
+	m, err := mission.NewFromJSON([]byte(missionString))
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+	s, err := m.GetStage(vars["name"])
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	history := s.History
+	if history == nil {
+		history = []mission.Transition{}
+	}
+	payload, _ := json.Marshal(history)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// GetMissionRelatedStages godoc
+// @Summary Gets every stage upstream or downstream of a stage in a mission, with their states.
+// @Description Returns the transitive closure of the stage's dependencies (upstream) or dependents (downstream), including on_success/on_failure handlers, ordered by depth, along with the current state of each stage.
+// @ID get-mission-related-stages
+// @Tags Mission
+// @Param x-access-key header string true "Houston Key"
+// @Param id path string true "The id of the mission"
+// @Param name path string true "The name of the stage"
+// @Param direction path string true "Either 'upstream' or 'downstream'"
+// @Param depth query int false "Maximum number of links to follow (default 0, no limit)"
+// @Success 200 {object} model.RelatedStagesResponse
+// @Failure 400,404,500 {object} model.Error
+// @Router /api/v1/missions/{id}/stages/{name}/{direction} [get]
+func (a *API) GetMissionRelatedStages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+	depth, err := depthParam(r)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+	res, err := a.MissionRelatedStages(key, vars["id"], vars["name"], vars["direction"], depth)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	payload, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// PostMissionPause godoc
+// @Summary Pauses an in-progress mission.
+// @Description No stages can be started while the mission is paused, but stages in progress can still be finished or failed. This route is transactional.
+// @ID post-mission-pause
+// @Tags Mission
+// @Param x-access-key header string true "Houston Key"
+// @Param id path string true "The id of the mission"
+// @Success 200 {object} model.MissionStageStateUpdateResponse
+// @Failure 404,500 {object} model.Error
+// @Router /api/v1/missions/{id}/pause [post]
+func (a *API) PostMissionPause(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+	res, err := a.PauseMission(key, mux.Vars(r)["id"])
+	writeMissionResponse(res, err, w)
+}
+
+// PostMissionResume godoc
+// @Summary Resumes a paused mission.
+// @Description Returns the stages that can be started now that the mission has been resumed. This route is transactional.
+// @ID post-mission-resume
+// @Tags Mission
+// @Param x-access-key header string true "Houston Key"
+// @Param id path string true "The id of the mission"
+// @Success 200 {object} model.MissionStageStateUpdateResponse
+// @Failure 404,500 {object} model.Error
+// @Router /api/v1/missions/{id}/resume [post]
+func (a *API) PostMissionResume(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+	res, err := a.ResumeMission(key, mux.Vars(r)["id"])
+	writeMissionResponse(res, err, w)
+}
+
+// PostMissionCancel godoc
+// @Summary Cancels an in-progress mission.
+// @Description Excludes every stage that hasn't started and marks the mission as complete, recording the reason and who cancelled it. This route is transactional.
+// @ID post-mission-cancel
+// @Tags Mission
+// @Param x-access-key header string true "Houston Key"
+// @Param Body body model.MissionCancelRequest false "The reason for cancelling the mission, and who cancelled it."
+// @Param id path string true "The id of the mission"
+// @Success 200 {object} model.MissionStageStateUpdateResponse
+// @Failure 404,500 {object} model.Error
+// @Router /api/v1/missions/{id}/cancel [post]
+func (a *API) PostMissionCancel(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+
+	var req model.MissionCancelRequest
+	if reqBody, _ := io.ReadAll(r.Body); len(reqBody) > 0 {
+		if err := json.Unmarshal(reqBody, &req); err != nil {
+			handleError(err, w)
+			return
+		}
+	}
+	if req.Actor == "" {
+		req.Actor = a.callerName(key, r)
+	}
+
+	res, err := a.CancelMission(key, mux.Vars(r)["id"], req.Reason, req.Actor)
+	writeMissionResponse(res, err, w)
+}
+
+// writeMissionResponse writes the response to a mission state change, or the error if there was one.
+func writeMissionResponse(res mission.Response, err error, w http.ResponseWriter) {
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+	payload, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
 // GetCompletedMissions godoc
 // @Summary Returns the IDs of all completed missions.
 // @Description Returns a list of the IDs of all completed (but not archived) missions for the key provided. These missions will also be in the list returned by GetMissions. This list is stored in a separate redis key for performance reasons. Completed missions should be deleted after being archived by the user to minimise the amount of storage required by the database.
@@ -12,6 +12,7 @@ import (
 	"github.com/gorilla/mux"
 	"io"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
@@ -36,6 +37,9 @@ func (a *API) GetPlan(w http.ResponseWriter, r *http.Request) {
 		handleError(&model.PlanNotFoundError{PlanName: planName}, w)
 		return
 	}
+	var p model.Plan
+	json.Unmarshal([]byte(plan), &p)
+	w.Header().Set("ETag", planETag(p.Version))
 	w.Header().Set("Content-Type", "application/json")
 	w.Write([]byte(plan))
 }
@@ -75,40 +79,158 @@ func (a *API) GetPlanAsMission(w http.ResponseWriter, r *http.Request) {
 	w.Write(planBytes)
 }
 
+// GetPlanGraph godoc
+// @Summary Gets the graph of a plan as DOT, Mermaid, or SVG.
+// @Description Returns the plan's graph, which can be rendered by Graphviz or Mermaid, or embedded as an image.
+// @ID get-plan-graph
+// @Tags Plan
+// @Param x-access-key header string true "Houston Key"
+// @Param name path string true "The name of the plan"
+// @Param format query string false "One of 'dot', 'mermaid', or 'svg' (default)"
+// @Success 200 {string} string
+// @Failure 400,404,500 {object} model.Error
+// @Router /api/v1/plans/{name}/graph [get]
+func (a *API) GetPlanGraph(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+	format := graphFormat(r)
+	graph, err := a.PlanGraph(key, vars["name"], format)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+	w.Header().Set("Content-Type", graphContentTypes[format])
+	w.Write([]byte(graph))
+}
+
 // PostPlan godoc
-// @Summary Updates the state of a stage in an in-progress mission.
-// @Description This route is transactional, meaning it will fail and result in 429 response if the same mission is currently being modified.
+// @Summary Saves a plan, creating a new version if the plan already exists.
+// @Description This route is transactional. If the If-Match header is given then the plan is only saved if it matches the ETag of the current version of the plan, otherwise 412 is returned.
 // @ID post-plan
 // @Tags Plan
 // @Param x-access-key header string true "Houston Key"
+// @Param If-Match header string false "The ETag of the version of the plan that is being updated"
 // @Param Body body model.Plan true "The id, services, stages and parameters of a plan."
 // @Success 200 {object} model.Success
-// @Failure 404,500 {object} model.Error
+// @Failure 400 {object} model.ValidationError
+// @Failure 404,412,500 {object} model.Error
 // @Router /api/v1/plans [post]
 func (a *API) PostPlan(w http.ResponseWriter, r *http.Request) {
 	reqBody, _ := io.ReadAll(r.Body)
 	var plan model.Plan
-	err := json.Unmarshal(reqBody, &plan)
+	err := decodeJSON(reqBody, &plan)
 	if err != nil {
-		// TODO: helpful error message for json validation errors, e.g. 'state is missing'
 		handleError(err, w)
 		return
 	}
 	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
-	err = a.SavePlan(key, plan)
+	version, err := a.SavePlanIfMatch(key, plan, a.callerName(key, r), r.Header.Get("If-Match"))
 	if err != nil {
 		handleError(err, w)
 		return
 	}
 
 	payload, _ := json.Marshal(model.Success{Message: "Created " + plan.Name})
+	w.Header().Set("ETag", planETag(version))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// PostPlanLint godoc
+// @Summary Checks a plan without saving it.
+// @Description Validates a plan and returns every problem found, including warnings about parts of the plan that are valid but are probably a mistake, such as redundant links or unused services.
+// @ID post-plan-lint
+// @Tags Plan
+// @Param x-access-key header string true "Houston Key"
+// @Param Body body model.Plan true "The id, services, stages and parameters of a plan."
+// @Success 200 {object} model.PlanLintResponse
+// @Failure 400 {object} model.ValidationError
+// @Failure 404,500 {object} model.Error
+// @Router /api/v1/plans/lint [post]
+func (a *API) PostPlanLint(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := io.ReadAll(r.Body)
+	var plan model.Plan
+	err := decodeJSON(reqBody, &plan)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	payload, _ := json.Marshal(a.LintPlan(plan))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// PostPlanDryRun godoc
+// @Summary Shows what would happen if a mission was created from a plan.
+// @Description Simulates a mission created from the plan with the stages, exclusions and skips given, without creating it. Returns the waves of stages that would be started together, the stages that would be excluded or skipped, and the critical path, estimated using the durations of stages in recent completed missions.
+// @ID post-plan-dry-run
+// @Tags Plan
+// @Param x-access-key header string true "Houston Key"
+// @Param name path string true "The name of the plan"
+// @Param Body body model.PlanDryRunRequest false "The stages to start from, and the stages to exclude and skip."
+// @Success 200 {object} model.PlanDryRunResponse
+// @Failure 400 {object} model.ValidationError
+// @Failure 404,500 {object} model.Error
+// @Router /api/v1/plans/{name}/dry-run [post]
+func (a *API) PostPlanDryRun(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := io.ReadAll(r.Body)
+	var req model.PlanDryRunRequest
+	if len(reqBody) > 0 {
+		if err := decodeJSON(reqBody, &req); err != nil {
+			handleError(err, w)
+			return
+		}
+	}
+	vars := mux.Vars(r)
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+
+	res, err := a.DryRun(key, vars["name"], req)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	payload, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// GetPlanRelatedStages godoc
+// @Summary Gets every stage upstream or downstream of a stage in a plan.
+// @Description Returns the transitive closure of the stage's dependencies (upstream) or dependents (downstream), including on_success/on_failure handlers, ordered by depth. Downstream stages are those affected if the stage fails or produces bad outputs.
+// @ID get-plan-related-stages
+// @Tags Plan
+// @Param x-access-key header string true "Houston Key"
+// @Param name path string true "The name of the plan"
+// @Param stage path string true "The name of the stage"
+// @Param direction path string true "Either 'upstream' or 'downstream'"
+// @Param depth query int false "Maximum number of links to follow (default 0, no limit)"
+// @Success 200 {object} model.RelatedStagesResponse
+// @Failure 400,404,500 {object} model.Error
+// @Router /api/v1/plans/{name}/stages/{stage}/{direction} [get]
+func (a *API) GetPlanRelatedStages(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+	depth, err := depthParam(r)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+	res, err := a.PlanRelatedStages(key, vars["name"], vars["stage"], vars["direction"], depth)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	payload, _ := json.Marshal(res)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(payload)
 }
 
 // DeletePlan godoc
 // @Summary Deletes a plan and all its missions from the database.
-// @Description Deletes a plan and associated missions given its name. Any missions in progress will be deleted.
+// @Description Deletes a plan and associated missions given its name. Any missions in progress will be deleted. Versions of the plan are kept, and if the plan is saved again its version numbers carry on from the last version.
 // @ID delete-plan
 // @Tags Plan
 // @Param x-access-key header string true "Houston Key"
@@ -122,6 +244,7 @@ func (a *API) DeletePlan(w http.ResponseWriter, r *http.Request) {
 	planName := vars["name"]
 	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
 
+	// versions of the plan are kept, so that the plans that missions ran can still be seen
 	wasDeleted := a.db.Delete(key, "p|"+planName)
 
 	activeMissions, ok := a.db.Get(key, "a|"+planName)
@@ -203,3 +326,104 @@ func (a *API) GetPlanMissions(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(payload)
 }
+
+// GetPlanVersions godoc
+// @Summary Gets all versions of a plan.
+// @Description Returns the version number, time saved, and author of every saved version of the plan, oldest first.
+// @ID get-plan-versions
+// @Tags Plan
+// @Param x-access-key header string true "Houston Key"
+// @Param name path string true "The name of the plan"
+// @Success 200 {array} model.PlanVersion
+// @Failure 404,500 {object} model.Error
+// @Router /api/v1/plans/{name}/versions [get]
+func (a *API) GetPlanVersions(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+	versions, err := a.PlanVersions(key, mux.Vars(r)["name"])
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+	payload, _ := json.Marshal(versions)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// GetPlanVersion godoc
+// @Summary Gets a version of a plan.
+// @Description Returns the plan definition as JSON, exactly as it was when the version was saved.
+// @ID get-plan-version
+// @Tags Plan
+// @Param x-access-key header string true "Houston Key"
+// @Param name path string true "The name of the plan"
+// @Param version path int true "The version number"
+// @Success 200 {object} model.Plan
+// @Failure 404,500 {object} model.Error
+// @Router /api/v1/plans/{name}/versions/{version} [get]
+func (a *API) GetPlanVersion(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	planName := vars["name"]
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+
+	version, err := strconv.Atoi(vars["version"])
+	if err != nil {
+		handleError(&model.PlanVersionNotFoundError{PlanName: planName, Version: vars["version"]}, w)
+		return
+	}
+	planString, ok := a.db.Get(key, planVersionField(planName, version))
+	if !ok {
+		handleError(&model.PlanVersionNotFoundError{PlanName: planName, Version: vars["version"]}, w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(planString))
+}
+
+// GetPlanDiff godoc
+// @Summary Compares two versions of a plan.
+// @Description Returns the stages that were added, removed, or changed between two versions of a plan. By default, the latest version is compared to the version before it.
+// @ID get-plan-diff
+// @Tags Plan
+// @Param x-access-key header string true "Houston Key"
+// @Param name path string true "The name of the plan"
+// @Param from query int false "The version to compare from"
+// @Param to query int false "The version to compare to"
+// @Success 200 {object} model.PlanDiff
+// @Failure 400,404,500 {object} model.Error
+// @Router /api/v1/plans/{name}/diff [get]
+func (a *API) GetPlanDiff(w http.ResponseWriter, r *http.Request) {
+	planName := mux.Vars(r)["name"]
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+
+	versions, err := a.PlanVersions(key, planName)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+	to := 0
+	if len(versions) > 0 {
+		to = versions[len(versions)-1].Version // the latest version
+	}
+	if v := r.URL.Query().Get("to"); v != "" {
+		if to, err = strconv.Atoi(v); err != nil {
+			handleError(fmt.Errorf("version to compare to must be a number, got '%v'", v), w)
+			return
+		}
+	}
+	from := to - 1
+	if v := r.URL.Query().Get("from"); v != "" {
+		if from, err = strconv.Atoi(v); err != nil {
+			handleError(fmt.Errorf("version to compare from must be a number, got '%v'", v), w)
+			return
+		}
+	}
+
+	diff, err := a.DiffPlanVersions(key, planName, from, to)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+	payload, _ := json.Marshal(diff)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
@@ -0,0 +1,76 @@
+package api
+
+import (
+	"encoding/json"
+	"github.com/datasparq-ai/houston/model"
+	"io"
+	"net/http"
+)
+
+// PostWorkLease godoc
+// @Summary Leases a stage that is ready to run to a worker.
+// @Description Finds a stage that is ready to run in any active mission, and is run by one of the services given, then starts it and leases it to the worker. The worker must finish or fail the stage, or renew the lease with a heartbeat, before the lease expires, otherwise the stage is returned to ready. The lease is null if no stages are ready.
+// @ID post-work-lease
+// @Tags Work
+// @Param x-access-key header string true "Houston Key"
+// @Param Body body model.WorkLeaseRequest true "The name of the worker and the services it runs."
+// @Success 200 {object} model.WorkLeaseResponse
+// @Failure 400 {object} model.ValidationError
+// @Failure 500 {object} model.Error
+// @Router /api/v1/work/lease [post]
+func (a *API) PostWorkLease(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := io.ReadAll(r.Body)
+	var req model.WorkLeaseRequest
+	if err := decodeJSON(reqBody, &req); err != nil {
+		handleError(err, w)
+		return
+	}
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+	if req.Worker == "" {
+		req.Worker = a.callerName(key, r)
+	}
+
+	res, err := a.LeaseStage(key, req)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	payload, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
+
+// PostWorkHeartbeat godoc
+// @Summary Renews the lease of a stage.
+// @Description Extends the lease of a stage that a worker is running. Only the worker that holds the lease can renew it, and leases that have expired can't be renewed.
+// @ID post-work-heartbeat
+// @Tags Work
+// @Param x-access-key header string true "Houston Key"
+// @Param Body body model.WorkHeartbeatRequest true "The name of the worker, and the mission and stage it is running."
+// @Success 200 {object} model.WorkLeaseResponse
+// @Failure 400 {object} model.ValidationError
+// @Failure 500 {object} model.Error
+// @Router /api/v1/work/heartbeat [post]
+func (a *API) PostWorkHeartbeat(w http.ResponseWriter, r *http.Request) {
+	reqBody, _ := io.ReadAll(r.Body)
+	var req model.WorkHeartbeatRequest
+	if err := decodeJSON(reqBody, &req); err != nil {
+		handleError(err, w)
+		return
+	}
+	key := r.Header.Get("x-access-key") // key has been checked by checkKey middleware
+	if req.Worker == "" {
+		req.Worker = a.callerName(key, r)
+	}
+
+	res, err := a.RenewLease(key, req)
+	if err != nil {
+		handleError(err, w)
+		return
+	}
+
+	payload, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(payload)
+}
@@ -50,17 +50,34 @@ func (a *API) initRouter() {
 	apiRouter.Use(a.checkKey)
 	apiRouter.HandleFunc("/plans/", a.GetPlans).Methods("GET")
 	apiRouter.HandleFunc("/plans", a.PostPlan).Methods("POST")
+	apiRouter.HandleFunc("/plans/lint", a.PostPlanLint).Methods("POST")
 	apiRouter.HandleFunc("/plans/{plan}/missions/{id}", a.GetMission).Methods("GET")
 	apiRouter.HandleFunc("/plans/{name}/missions", a.GetPlanMissions).Methods("GET")
 	apiRouter.HandleFunc("/plans/{name}/m", a.GetPlanAsMission).Methods("GET")
+	apiRouter.HandleFunc("/plans/{name}/versions", a.GetPlanVersions).Methods("GET")
+	apiRouter.HandleFunc("/plans/{name}/versions/{version}", a.GetPlanVersion).Methods("GET")
+	apiRouter.HandleFunc("/plans/{name}/diff", a.GetPlanDiff).Methods("GET")
+	apiRouter.HandleFunc("/plans/{name}/graph", a.GetPlanGraph).Methods("GET")
+	apiRouter.HandleFunc("/plans/{name}/dry-run", a.PostPlanDryRun).Methods("POST")
+	apiRouter.HandleFunc("/plans/{name}/stages/{stage}/{direction:upstream|downstream}", a.GetPlanRelatedStages).Methods("GET")
 	apiRouter.HandleFunc("/plans/{name}", a.GetPlan).Methods("GET")
 	apiRouter.HandleFunc("/plans/{name}", a.DeletePlan).Methods("DELETE")
 	apiRouter.HandleFunc("/missions/", a.GetMissions).Methods("GET")
 	apiRouter.HandleFunc("/missions", a.PostMission).Methods("POST")
 	apiRouter.HandleFunc("/missions/{id}/stages/{name}", a.PostMissionStage).Methods("POST")
+	apiRouter.HandleFunc("/missions/{id}/stages/{name}/history", a.GetMissionStageHistory).Methods("GET")
+	apiRouter.HandleFunc("/missions/{id}/stages/{name}/{direction:upstream|downstream}", a.GetMissionRelatedStages).Methods("GET")
+	apiRouter.HandleFunc("/missions/{id}/pause", a.PostMissionPause).Methods("POST")
+	apiRouter.HandleFunc("/missions/{id}/resume", a.PostMissionResume).Methods("POST")
+	apiRouter.HandleFunc("/missions/{id}/cancel", a.PostMissionCancel).Methods("POST")
 	apiRouter.HandleFunc("/missions/{id}", a.GetMission).Methods("GET")
 	apiRouter.HandleFunc("/missions/{id}/report", a.GetMissionReport).Methods("GET")
+	apiRouter.HandleFunc("/missions/{id}/graph", a.GetMissionGraph).Methods("GET")
 	apiRouter.HandleFunc("/missions/{id}", a.deleteMission).Methods("DELETE")
+
+	apiRouter.HandleFunc("/work/lease", a.PostWorkLease).Methods("POST")
+	apiRouter.HandleFunc("/work/heartbeat", a.PostWorkHeartbeat).Methods("POST")
+
 	apiRouter.HandleFunc("/completed", a.GetCompletedMissions).Methods("GET")
 	apiRouter.HandleFunc("/logs", a.GetLogs).Methods("GET")
 
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"github.com/datasparq-ai/houston/mission"
+	"github.com/datasparq-ai/houston/model"
+	"strings"
+)
+
+// startChildMission creates a child mission if the stage that has just been started runs another plan, and links the
+// parent and child missions to each other. The child mission's params are the stage's params as rendered when it was
+// started. If the child mission can't be created then the stage is failed.
+func (a *API) startChildMission(key string, missionId string, stageName string, params map[string]interface{}, missionBytes []byte) error {
+	m, err := mission.NewFromJSON(missionBytes)
+	if err != nil {
+		return err
+	}
+	s, err := m.GetStage(stageName)
+	if err != nil || s.SubPlan() == "" {
+		return nil
+	}
+
+	childId, err := a.CreateMissionFromPlan(key, s.SubPlan(), "", params)
+	if err != nil {
+		keyLog.Errorf("Couldn't create child mission for stage %s in mission %s: %v", stageName, missionId, err)
+		if _, failErr := a.UpdateStage(key, missionId, stageName, model.MissionStageStateUpdate{State: "failed", Fatal: true, Message: "couldn't create child mission: " + err.Error()}); failErr != nil {
+			keyLog.Errorf("Couldn't fail stage %s in mission %s: %v", stageName, missionId, failErr)
+		}
+		return err
+	}
+
+	childBytes, err := a.updateMission(key, childId, func(child *mission.Mission) error {
+		child.Parent = missionId
+		child.ParentStage = stageName
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	missionBytes, err = a.updateMission(key, missionId, func(m *mission.Mission) error {
+		return m.SetChild(stageName, childId)
+	})
+	if err != nil {
+		return err
+	}
+	keyLog.Infof("Stage %s in mission %s is running child mission %s", stageName, missionId, childId)
+	a.ws <- message{key, "missionUpdate", childBytes}
+	a.ws <- message{key, "missionUpdate", missionBytes}
+
+	// the child mission may have already ended before it was linked to its parent
+	if childString, ok := a.db.Get(key, childId); ok {
+		a.updateParentStage(key, []byte(childString))
+	}
+
+	// nothing else will trigger the child mission's first stages
+	if child, err := mission.NewFromJSON(childBytes); err == nil {
+		a.dispatchStages(key, child.Next(), childBytes)
+	}
+	return nil
+}
+
+// updateParentStage finishes the parent stage of a child mission once the child mission is complete, or fails it if
+// the child mission was cancelled, timed out, or can't progress because one of its stages has failed.
+func (a *API) updateParentStage(key string, missionBytes []byte) {
+	m, err := mission.NewFromJSON(missionBytes)
+	if err != nil || m.Parent == "" {
+		return
+	}
+
+	update := model.MissionStageStateUpdate{State: "finished", Message: "child mission " + m.Id + " is complete"}
+	if m.Cancellation != nil {
+		update = model.MissionStageStateUpdate{State: "failed", Fatal: true, Message: "child mission " + m.Id + " was cancelled"}
+	} else if m.TimedOut() {
+		update = model.MissionStageStateUpdate{State: "failed", Fatal: true, Message: "child mission " + m.Id + " timed out"}
+	} else if m.End.IsZero() {
+		hasFailed, fatal := m.HasFailed()
+		if !hasFailed {
+			return
+		}
+		update = model.MissionStageStateUpdate{State: "failed", Fatal: fatal, Message: "child mission " + m.Id + " has a failed stage"}
+	}
+
+	// the parent stage may have been restarted, in which case it will be running a different child mission
+	parentString, ok := a.db.Get(key, m.Parent)
+	if !ok {
+		return
+	}
+	parent, err := mission.NewFromJSON([]byte(parentString))
+	if err != nil || !parent.IsRunningChild(m.ParentStage, m.Id) {
+		return
+	}
+
+	keyLog.Infof("Child mission %s has ended, setting stage %s in mission %s to %s", m.Id, m.ParentStage, m.Parent, update.State)
+	if _, err := a.UpdateStage(key, m.Parent, m.ParentStage, update); err != nil {
+		keyLog.Errorf("Couldn't update stage %s in mission %s: %v", m.ParentStage, m.Parent, err)
+	}
+}
+
+// subPlans returns a function that gives the plans run by the sub-plan stages of a saved plan, which is used to find
+// chains of plans that would run a plan within itself. Plans that aren't saved don't run any sub-plans.
+func (a *API) subPlans(key string) func(plan string) []string {
+	return func(planName string) []string {
+		plan, err := a.savedPlan(key, planName)
+		if err != nil {
+			return nil
+		}
+		var plans []string
+		for _, s := range plan.Stages {
+			if strings.HasPrefix(s.Service, "plan:") {
+				plans = append(plans, strings.TrimPrefix(s.Service, "plan:"))
+			}
+		}
+		return plans
+	}
+}
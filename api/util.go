@@ -3,8 +3,10 @@ package api
 import (
 	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math/rand"
+	"net"
 	"net/http"
 	"strings"
 	"time"
@@ -46,19 +48,82 @@ func NewMissionFromPlan(plan *model.Plan) *mission.Mission {
 
 	for stageIdx := range plan.Stages {
 		s := mission.Stage{
-			Name:       plan.Stages[stageIdx].Name,
-			Upstream:   plan.Stages[stageIdx].Upstream,
-			Downstream: plan.Stages[stageIdx].Downstream,
-			Params:     plan.Stages[stageIdx].Params,
+			Name:        plan.Stages[stageIdx].Name,
+			Service:     plan.Stages[stageIdx].Service,
+			Upstream:    plan.Stages[stageIdx].Upstream,
+			Downstream:  plan.Stages[stageIdx].Downstream,
+			Params:      plan.Stages[stageIdx].Params,
+			Timeout:     plan.Stages[stageIdx].Timeout,
+			TriggerRule: plan.Stages[stageIdx].TriggerRule,
+			OnFailure:   plan.Stages[stageIdx].OnFailure,
+			OnSuccess:   plan.Stages[stageIdx].OnSuccess,
+			Finalizer:   plan.Stages[stageIdx].Finalizer,
+			MapOver:     plan.Stages[stageIdx].MapOver,
+		}
+		if r := plan.Stages[stageIdx].Retry; r != nil {
+			s.Retry = &mission.RetryPolicy{MaxAttempts: r.MaxAttempts, Backoff: r.Backoff, Delay: r.Delay}
 		}
 		stages = append(stages, &s)
 	}
 
 	m := mission.New(plan.Name, stages)
+	for _, service := range plan.Services {
+		m.Services = append(m.Services, service.Name)
+		if service.Trigger != nil {
+			if m.Triggers == nil {
+				m.Triggers = make(map[string]mission.Trigger)
+			}
+			m.Triggers[service.Name] = service.Trigger
+		}
+	}
+	m.Timeout = plan.Timeout
+	m.Params = plan.Params
+	m.PlanVersion = plan.Version
+
+	// plans that haven't been saved don't have a layout yet
+	layout := plan.Layout
+	if layout == nil {
+		layout = m.Layout(pinnedPositions(plan))
+	}
+	m.SetPositions(layout)
 
 	return &m
 }
 
+// pinnedPositions returns the positions of the stages in a plan that have been given a position by the plan's author.
+func pinnedPositions(plan *model.Plan) map[string]mission.Position {
+	pinned := make(map[string]mission.Position)
+	for _, s := range plan.Stages {
+		if s.X != nil && s.Y != nil {
+			pinned[s.Name] = mission.Position{X: *s.X, Y: *s.Y}
+		}
+	}
+	return pinned
+}
+
+// mergeParams combines sets of params, where the values in later sets override the values in earlier sets.
+func mergeParams(paramSets ...map[string]interface{}) map[string]interface{} {
+	merged := make(map[string]interface{})
+	for _, params := range paramSets {
+		for k, v := range params {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// callerName identifies who made a request. This is the name of the key if it has one, otherwise the caller's IP
+// address.
+func (a *API) callerName(key string, r *http.Request) string {
+	if name, ok := a.db.Get(key, "n"); ok && name != "" {
+		return name
+	}
+	if ip, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return ip
+	}
+	return r.RemoteAddr
+}
+
 // handleError writes an error http response given an error object
 func handleError(err error, w http.ResponseWriter) {
 	keyLog.Error(err)
@@ -70,8 +135,43 @@ func handleError(err error, w http.ResponseWriter) {
 	}
 
 	payload, _ := json.Marshal(res)
+	if problems := errorProblems(err); len(problems) > 0 {
+		payload, _ = json.Marshal(model.ValidationError{Type: res.Type, Message: res.Message, Code: res.Code, Problems: problems})
+	}
 
 	w.WriteHeader(res.Code)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(payload)
 }
+
+// errorProblems returns the problems listed by a validation error, if there are any.
+func errorProblems(err error) []mission.Problem {
+	switch e := err.(type) {
+	case *mission.PlanValidationError:
+		return e.Problems
+	case *model.InvalidRequestError:
+		return e.Problems
+	}
+	return nil
+}
+
+// decodeJSON parses a JSON request body into v. If the body can't be parsed, an InvalidRequestError is returned giving
+// the location of the problem.
+func decodeJSON(data []byte, v interface{}) error {
+	err := json.Unmarshal(data, v)
+	if err == nil {
+		return nil
+	}
+	p := mission.Problem{Code: "invalid_json", Severity: mission.SeverityError, Message: err.Error()}
+	var syntaxError *json.SyntaxError
+	var typeError *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxError):
+		p.Message = fmt.Sprintf("body is not valid JSON: %v (at character %v)", syntaxError, syntaxError.Offset)
+	case errors.As(err, &typeError):
+		p.Code = "invalid_type"
+		p.Path = typeError.Field
+		p.Message = fmt.Sprintf("'%v' must be of type %v, not %v", typeError.Field, typeError.Type, typeError.Value)
+	}
+	return &model.InvalidRequestError{Problems: []mission.Problem{p}}
+}
@@ -0,0 +1,169 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/datasparq-ai/houston/model"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Every time a plan is saved it is given a new version number, starting at 1. The list of versions is stored as JSON in
+// the 'v|<plan-name>' field, and each version of the plan is stored in the 'v|<plan-name>|<version>' field. Versions
+// are never modified, so any version can be used to see exactly which plan a mission ran, or to roll back a change.
+// Versions are kept when a plan is deleted, and if the plan is saved again its version numbers carry on from the last
+// version. The version number is also used as the plan's ETag, which allows clients to avoid overwriting each other's
+// changes.
+
+// planVersionField is the database field that a version of a plan is stored in.
+func planVersionField(planName string, version int) string {
+	return fmt.Sprintf("v|%v|%v", planName, version)
+}
+
+// recordPlanVersion adds a version to the list of versions of a plan within a transaction. The version number is given
+// by SavePlanIfMatch, so the list is kept in order in case versions are recorded out of order.
+func (a *API) recordPlanVersion(key string, planName string, version model.PlanVersion) error {
+	txnFunc := func(versionsString string) (string, error) {
+		var versions []model.PlanVersion
+		if versionsString != "" {
+			if err := json.Unmarshal([]byte(versionsString), &versions); err != nil {
+				return "", err
+			}
+		}
+		versions = append(versions, version)
+		sort.Slice(versions, func(i, j int) bool { return versions[i].Version < versions[j].Version })
+		versionsBytes, err := json.Marshal(versions)
+		return string(versionsBytes), err
+	}
+
+	var err error
+	for attempts := 0; attempts < 3; attempts++ {
+		err = a.db.DoTransaction(txnFunc, key, "v|"+planName)
+		if _, locked := err.(*model.TransactionFailedError); !locked {
+			break
+		}
+		time.Sleep(10 * time.Millisecond * time.Duration((attempts+1)^2))
+	}
+	return err
+}
+
+// latestPlanVersion returns the highest version number that has been recorded for a plan, or 0 if it has never been
+// saved.
+func (a *API) latestPlanVersion(key string, planName string) int {
+	versions, err := a.PlanVersions(key, planName)
+	if err != nil || len(versions) == 0 {
+		return 0
+	}
+	return versions[len(versions)-1].Version // versions are kept in order by recordPlanVersion
+}
+
+// planETag gives the ETag of a version of a plan, which is its version number as a quoted string.
+func planETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// etagMatches checks whether an If-Match header matches the ETag of the plan that is currently saved. The header may
+// contain several ETags separated by commas, or '*' to match any saved plan.
+func etagMatches(ifMatch string, exists bool, version int) bool {
+	if !exists {
+		return false
+	}
+	for _, etag := range strings.Split(ifMatch, ",") {
+		etag = strings.TrimPrefix(strings.TrimSpace(etag), "W/")
+		if etag == "*" || etag == planETag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// PlanVersions returns every saved version of a plan, oldest first.
+func (a *API) PlanVersions(key string, planName string) ([]model.PlanVersion, error) {
+	versionsString, ok := a.db.Get(key, "v|"+planName)
+	if !ok {
+		return nil, &model.PlanNotFoundError{PlanName: planName}
+	}
+	var versions []model.PlanVersion
+	err := json.Unmarshal([]byte(versionsString), &versions)
+	return versions, err
+}
+
+// PlanVersion returns a saved version of a plan.
+func (a *API) PlanVersion(key string, planName string, version int) (model.Plan, error) {
+	var plan model.Plan
+	planString, ok := a.db.Get(key, planVersionField(planName, version))
+	if !ok {
+		return plan, &model.PlanVersionNotFoundError{PlanName: planName, Version: strconv.Itoa(version)}
+	}
+	err := json.Unmarshal([]byte(planString), &plan)
+	return plan, err
+}
+
+// DiffPlanVersions compares two saved versions of a plan.
+func (a *API) DiffPlanVersions(key string, planName string, from int, to int) (model.PlanDiff, error) {
+	fromPlan, err := a.PlanVersion(key, planName, from)
+	if err != nil {
+		return model.PlanDiff{}, err
+	}
+	toPlan, err := a.PlanVersion(key, planName, to)
+	if err != nil {
+		return model.PlanDiff{}, err
+	}
+	return diffPlans(fromPlan, toPlan), nil
+}
+
+// diffPlans lists the stages that were added, removed, or changed between two plans, and the plan attributes that
+// changed. Stages are matched by name.
+func diffPlans(from model.Plan, to model.Plan) model.PlanDiff {
+	diff := model.PlanDiff{
+		From:    from.Version,
+		To:      to.Version,
+		Plan:    changedAttributes(from, to, "name", "stages", "version", "layout"), // the layout is computed from the stages
+		Added:   []string{},
+		Removed: []string{},
+		Changed: []model.StageDiff{},
+	}
+
+	fromStages := make(map[string]*model.Stage, len(from.Stages))
+	for _, s := range from.Stages {
+		fromStages[s.Name] = s
+	}
+	toStages := make(map[string]bool, len(to.Stages))
+	for _, s := range to.Stages {
+		toStages[s.Name] = true
+		previous, ok := fromStages[s.Name]
+		if !ok {
+			diff.Added = append(diff.Added, s.Name)
+			continue
+		}
+		if attributes := changedAttributes(*previous, *s); len(attributes) > 0 {
+			diff.Changed = append(diff.Changed, model.StageDiff{Name: s.Name, Attributes: attributes})
+		}
+	}
+	for _, s := range from.Stages {
+		if !toStages[s.Name] {
+			diff.Removed = append(diff.Removed, s.Name)
+		}
+	}
+	return diff
+}
+
+// changedAttributes returns the JSON names of the fields that differ between two structs of the same type, apart from
+// those that are ignored.
+func changedAttributes(from interface{}, to interface{}, ignore ...string) []string {
+	attributes := []string{}
+	fromValue, toValue := reflect.ValueOf(from), reflect.ValueOf(to)
+	for i := 0; i < fromValue.NumField(); i++ {
+		name, _, _ := strings.Cut(fromValue.Type().Field(i).Tag.Get("json"), ",")
+		if strings.Contains(","+strings.Join(ignore, ",")+",", ","+name+",") {
+			continue
+		}
+		if !reflect.DeepEqual(fromValue.Field(i).Interface(), toValue.Field(i).Interface()) {
+			attributes = append(attributes, name)
+		}
+	}
+	return attributes
+}
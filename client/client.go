@@ -16,9 +16,10 @@ type Auth struct {
 }
 
 type Client struct {
-	BaseUrl string
-	Key     string
-	Auth    Auth
+	BaseUrl     string
+	Key         string
+	Auth        Auth
+	ServiceAuth map[string]ServiceAuth // credentials used to trigger services that require auth, by service name
 }
 
 // New creates a Houston client instance. One instance uses one API key to make all requests.
@@ -65,7 +66,7 @@ func New(key string, baseUrl string) Client {
 		auth = Auth{"admin", envPass}
 	}
 
-	client := Client{baseUrl, key, auth}
+	client := Client{BaseUrl: baseUrl, Key: key, Auth: auth}
 
 	// Check the health of the selected API server. This will only produce a warning if it fails.
 	healthCheckError := healthCheck(baseUrl)
@@ -126,6 +127,12 @@ func (client *Client) CreateMission(plan string, id string, params map[string]in
 	return res, err
 }
 
+func (client *Client) GetMissionGraph(missionId string, format string) (string, error) {
+	return client.getGraph("/missions/"+missionId, format)
+}
+func (client *Client) GetMissionRelatedStages(mission, stage, direction string, depth int) (model.RelatedStagesResponse, error) {
+	return client.getRelatedStages(fmt.Sprintf("/missions/%v/stages/%v/%v?depth=%v", mission, stage, direction, depth))
+}
 func (client *Client) ListActiveMissions() ([]string, error) {
 	var missions []string
 	resp := client.get("/missions")
@@ -144,6 +151,13 @@ func (client *Client) DeleteMission(missionId string) (model.Mission, error) {
 	return mission, err
 }
 
+func (client *Client) GetStageHistory(mission, stage string) ([]model.MissionStageTransition, error) {
+	var history []model.MissionStageTransition
+	resp := client.get(fmt.Sprintf("/missions/%v/stages/%v/history", mission, stage))
+	err := parseResponse(resp, &history)
+	return history, err
+}
+
 func (client *Client) StartStage(mission, stage string, ignoreDependencies bool) (model.MissionStageStateUpdateResponse, error) {
 	reqBody := model.MissionStageStateUpdate{State: "started", IgnoreDependencies: ignoreDependencies}
 	return client.postMissionsStages(mission, stage, reqBody)
@@ -152,8 +166,12 @@ func (client *Client) FinishStage(mission, stage string, ignoreDependencies bool
 	reqBody := model.MissionStageStateUpdate{State: "finished", IgnoreDependencies: ignoreDependencies}
 	return client.postMissionsStages(mission, stage, reqBody)
 }
-func (client *Client) FailStage(mission, stage string) (model.MissionStageStateUpdateResponse, error) {
-	reqBody := model.MissionStageStateUpdate{State: "failed", IgnoreDependencies: false}
+func (client *Client) FinishStageWithOutputs(mission, stage string, ignoreDependencies bool, outputs map[string]interface{}) (model.MissionStageStateUpdateResponse, error) {
+	reqBody := model.MissionStageStateUpdate{State: "finished", IgnoreDependencies: ignoreDependencies, Outputs: outputs}
+	return client.postMissionsStages(mission, stage, reqBody)
+}
+func (client *Client) FailStage(mission, stage string, stageError *model.MissionStageError) (model.MissionStageStateUpdateResponse, error) {
+	reqBody := model.MissionStageStateUpdate{State: "failed", IgnoreDependencies: false, Error: stageError}
 	return client.postMissionsStages(mission, stage, reqBody)
 }
 func (client *Client) ExcludeStage(mission, stage string) (model.MissionStageStateUpdateResponse, error) {
@@ -164,6 +182,21 @@ func (client *Client) SkipStage(mission, stage string) (model.MissionStageStateU
 	reqBody := model.MissionStageStateUpdate{State: "skipped"}
 	return client.postMissionsStages(mission, stage, reqBody)
 }
+func (client *Client) ResetStage(mission, stage string) (model.MissionStageStateUpdateResponse, error) {
+	reqBody := model.MissionStageStateUpdate{State: "reset"}
+	return client.postMissionsStages(mission, stage, reqBody)
+}
+
+func (client *Client) PauseMission(mission string) (model.MissionStageStateUpdateResponse, error) {
+	return client.postMissionsAction(mission, "pause", nil)
+}
+func (client *Client) ResumeMission(mission string) (model.MissionStageStateUpdateResponse, error) {
+	return client.postMissionsAction(mission, "resume", nil)
+}
+func (client *Client) CancelMission(mission, reason string) (model.MissionStageStateUpdateResponse, error) {
+	reqJSON, _ := json.Marshal(model.MissionCancelRequest{Reason: reason})
+	return client.postMissionsAction(mission, "cancel", reqJSON)
+}
 
 func (client *Client) SavePlan(filePath string) error {
 	plan, err := loadPlan(filePath)
@@ -173,6 +206,30 @@ func (client *Client) SavePlan(filePath string) error {
 	return client.postPlans([]byte(plan))
 }
 
+func (client *Client) LintPlan(filePath string) (model.PlanLintResponse, error) {
+	plan, err := loadPlan(filePath)
+	if err != nil {
+		return model.PlanLintResponse{}, err
+	}
+	return client.postPlansLint([]byte(plan))
+}
+func (client *Client) DryRun(plan string, stages, exclude, skip []string, params map[string]interface{}) (model.PlanDryRunResponse, error) {
+	return client.postPlansDryRun(plan, model.PlanDryRunRequest{Stages: stages, Exclude: exclude, Skip: skip, Params: params})
+}
+func (client *Client) LeaseStage(worker string, services []string, duration string) (model.WorkLeaseResponse, error) {
+	return client.postWorkLease(model.WorkLeaseRequest{Worker: worker, Services: services, Duration: duration})
+}
+func (client *Client) RenewLease(worker, mission, stage, duration string) (model.WorkLeaseResponse, error) {
+	return client.postWorkHeartbeat(model.WorkHeartbeatRequest{Worker: worker, MissionId: mission, Stage: stage, Duration: duration})
+}
+func (client *Client) FinishLeasedStage(worker, mission, stage string) (model.MissionStageStateUpdateResponse, error) {
+	reqBody := model.MissionStageStateUpdate{State: "finished", Worker: worker}
+	return client.postMissionsStages(mission, stage, reqBody)
+}
+func (client *Client) FailLeasedStage(worker, mission, stage string, stageError *model.MissionStageError) (model.MissionStageStateUpdateResponse, error) {
+	reqBody := model.MissionStageStateUpdate{State: "failed", Error: stageError, Worker: worker}
+	return client.postMissionsStages(mission, stage, reqBody)
+}
 func (client *Client) CreateKey(id, name, password string) (string, error) {
 	key := model.Key{
 		Id:   id,
@@ -193,6 +250,33 @@ func (client *Client) GetPlan(name string) (model.Plan, error) {
 	return plan, err
 }
 
+func (client *Client) ListPlanVersions(name string) ([]model.PlanVersion, error) {
+	var versions []model.PlanVersion
+	resp := client.get("/plans/" + name + "/versions")
+	err := parseResponse(resp, &versions)
+	return versions, err
+}
+
+func (client *Client) GetPlanVersion(name string, version int) (model.Plan, error) {
+	var plan model.Plan
+	resp := client.get(fmt.Sprintf("/plans/%v/versions/%v", name, version))
+	err := parseResponse(resp, &plan)
+	return plan, err
+}
+
+func (client *Client) DiffPlanVersions(name string, from, to int) (model.PlanDiff, error) {
+	var diff model.PlanDiff
+	resp := client.get(fmt.Sprintf("/plans/%v/diff?from=%v&to=%v", name, from, to))
+	err := parseResponse(resp, &diff)
+	return diff, err
+}
+
+func (client *Client) GetPlanGraph(name string, format string) (string, error) {
+	return client.getGraph("/plans/"+name, format)
+}
+func (client *Client) GetPlanRelatedStages(plan, stage, direction string, depth int) (model.RelatedStagesResponse, error) {
+	return client.getRelatedStages(fmt.Sprintf("/plans/%v/stages/%v/%v?depth=%v", plan, stage, direction, depth))
+}
 func (client *Client) DeletePlan(name string) error {
 	var success model.Success
 	resp := client.delete("/plans/" + name)
@@ -1,6 +1,15 @@
 package client
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/datasparq-ai/houston/mission"
+	"github.com/datasparq-ai/houston/model"
+)
 
 // Start starts a new mission from the plan provided
 func Start(plan string, id string, stages []string, exclude []string, skip []string, params map[string]interface{}) error {
@@ -27,12 +36,29 @@ func Start(plan string, id string, stages []string, exclude []string, skip []str
 			return err
 		}
 	}
-	// TODO: if stages is empty, determine starting stages
-	//for _, s := range stages {
-	// TODO: trigger stages (find service, determine trigger method)
-	//client.Trigger(s)
-	//}
 	fmt.Println("New mission started with ID: " + mission.Id)
+
+	// stages given are started with their dependencies ignored, otherwise the mission starts from its root stages
+	m, err := client.getMission(mission.Id)
+	if err != nil {
+		return err
+	}
+	stages = nonEmpty(stages)
+	ignoreDependencies := len(stages) > 0
+	if !ignoreDependencies {
+		stages = m.Next()
+	}
+	for _, s := range stages {
+		err := client.trigger(&m, s, ignoreDependencies)
+		switch {
+		case errors.Is(err, ErrWaitingForWorker):
+			fmt.Printf("Stage '%v' is waiting for a worker\n", s)
+		case err != nil:
+			fmt.Printf("Warning: stage '%v' was not triggered: %v\n", s, err)
+		default:
+			fmt.Printf("Triggered stage '%v'\n", s)
+		}
+	}
 	return nil
 }
 
@@ -46,6 +72,110 @@ func Save(plan string) error {
 	return nil
 }
 
+// DryRun prints what would happen if a mission was started with Start, without starting it.
+func DryRun(plan string, stages []string, exclude []string, skip []string, params map[string]interface{}) error {
+	client := New("", "")
+	res, err := client.DryRun(plan, nonEmpty(stages), nonEmpty(exclude), nonEmpty(skip), params)
+	if err != nil {
+		return err
+	}
+	for i, wave := range res.Waves {
+		fmt.Printf("Wave %v: %v\n", i+1, strings.Join(wave, ", "))
+	}
+	if len(res.Excluded) > 0 {
+		fmt.Printf("Excluded: %v\n", strings.Join(res.Excluded, ", "))
+	}
+	if len(res.Skipped) > 0 {
+		fmt.Printf("Skipped: %v\n", strings.Join(res.Skipped, ", "))
+	}
+	if len(res.Failed) > 0 {
+		fmt.Printf("Can't be expanded: %v\n", strings.Join(res.Failed, ", "))
+	}
+	fmt.Printf("Critical path: %v (estimated %v)\n", strings.Join(res.CriticalPath, " > "), res.EstimatedDuration)
+	return nil
+}
+
+// nonEmpty removes empty strings from a list of stage names given on the command line.
+func nonEmpty(names []string) []string {
+	var result []string
+	for _, n := range names {
+		if n != "" {
+			result = append(result, n)
+		}
+	}
+	return result
+}
+
+// Impact prints every stage downstream of a stage in a saved plan, or upstream if upstream is true. If a mission ID is
+// given then the stages of the mission are used instead, and their states are printed.
+func Impact(plan string, missionId string, stage string, upstream bool, depth int) error {
+	client := New("", "")
+	direction := "downstream"
+	if upstream {
+		direction = "upstream"
+	}
+	var res model.RelatedStagesResponse
+	var err error
+	if missionId != "" {
+		res, err = client.GetMissionRelatedStages(missionId, stage, direction, depth)
+	} else {
+		res, err = client.GetPlanRelatedStages(plan, stage, direction, depth)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%v stages %v of '%v':\n", len(res.Stages), direction, stage)
+	for _, s := range res.Stages {
+		line := strings.Repeat("  ", s.Depth) + s.Name
+		if s.State != "" {
+			line += " [" + s.State + "]"
+		}
+		if len(s.Outputs) > 0 {
+			line += " outputs: " + strings.Join(s.Outputs, ", ")
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// Lint checks a plan without saving it and prints every problem found. An error is returned if the plan is invalid.
+func Lint(plan string) error {
+	client := New("", "")
+	res, err := client.LintPlan(plan)
+	if err != nil {
+		return err
+	}
+	for _, p := range res.Problems {
+		fmt.Printf("%v: %v\n", p.Severity, p)
+	}
+	if !res.Valid {
+		return &mission.PlanValidationError{Detail: fmt.Sprintf("found %v problems", len(res.Problems)), Problems: res.Problems}
+	}
+	fmt.Printf("Plan is valid (%v warnings).\n", len(res.Problems))
+	return nil
+}
+
+// Graph prints the graph of a saved plan, or of a mission if a mission ID is given, in the format given. If output is
+// given then the graph is written to that file instead.
+func Graph(plan string, missionId string, format string, output string) error {
+	client := New("", "")
+	var graph string
+	var err error
+	if missionId != "" {
+		graph, err = client.GetMissionGraph(missionId, format)
+	} else {
+		graph, err = client.GetPlanGraph(plan, format)
+	}
+	if err != nil {
+		return err
+	}
+	if output != "" {
+		return os.WriteFile(output, []byte(graph), 0644)
+	}
+	fmt.Print(graph)
+	return nil
+}
+
 func CreateKey(id, name, password string) error {
 	client := New("", "")
 	key, err := client.CreateKey(id, name, password)
@@ -67,3 +197,27 @@ func ListKeys(password string) error {
 	}
 	return nil
 }
+
+// Worker runs the stages of the services given as subprocesses, until it is stopped. Stages are leased from the API,
+// and if none are ready the worker waits for the poll interval before trying again. Services must be given so that the
+// worker only runs the commands that it's expected to, since anyone who can save a plan can define an exec trigger.
+func Worker(name string, services []string, poll time.Duration, leaseDuration string) error {
+	client := New("", "")
+	if name == "" {
+		name, _ = os.Hostname()
+	}
+	services = nonEmpty(services)
+	if len(services) == 0 {
+		return fmt.Errorf("at least one service must be given for the worker to run")
+	}
+	fmt.Printf("Worker '%v' is waiting for stages of %v\n", name, strings.Join(services, ", "))
+	for {
+		ran, err := client.RunNextStage(name, services, leaseDuration)
+		if err != nil {
+			fmt.Printf("Warning: %v\n", err)
+		}
+		if !ran {
+			time.Sleep(poll)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/datasparq-ai/houston/model"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 )
 
@@ -106,6 +107,26 @@ func (client *Client) postKey(reqBody model.Key) (string, error) {
 	return string(responseBody), err
 }
 
+// getGraph returns a string instead of JSON
+func (client *Client) getGraph(path string, format string) (string, error) {
+	resp := client.get(path + "/graph?format=" + url.QueryEscape(format))
+	responseBody, err := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		if err != nil {
+			return "", handleInvalidResponse(err)
+		}
+		return "", handleErrorResponse(responseBody)
+	}
+	return string(responseBody), err
+}
+
+func (client *Client) getRelatedStages(path string) (model.RelatedStagesResponse, error) {
+	var res model.RelatedStagesResponse
+	resp := client.get(path)
+	err := parseResponse(resp, &res)
+	return res, err
+}
+
 func (client *Client) postMissions(reqBody model.MissionCreateRequest) (model.MissionCreatedResponse, error) {
 	var missionResponse model.MissionCreatedResponse
 	reqJSON, _ := json.Marshal(reqBody)
@@ -124,9 +145,48 @@ func (client *Client) postMissionsStages(mission, stage string, reqBody model.Mi
 	return missionResponse, err
 }
 
+func (client *Client) postMissionsAction(mission, action string, reqBody []byte) (model.MissionStageStateUpdateResponse, error) {
+	var missionResponse model.MissionStageStateUpdateResponse
+	path := fmt.Sprintf("/missions/%v/%v", mission, action)
+	resp := client.post(path, reqBody)
+	err := parseResponse(resp, &missionResponse)
+	return missionResponse, err
+}
+
 func (client *Client) postPlans(reqBody []byte) error {
 	var success model.Success
 	resp := client.post("/plans", reqBody)
 	err := parseResponse(resp, &success)
 	return err
 }
+
+func (client *Client) postPlansDryRun(plan string, reqBody model.PlanDryRunRequest) (model.PlanDryRunResponse, error) {
+	var res model.PlanDryRunResponse
+	reqJSON, _ := json.Marshal(reqBody)
+	resp := client.post("/plans/"+plan+"/dry-run", reqJSON)
+	err := parseResponse(resp, &res)
+	return res, err
+}
+
+func (client *Client) postPlansLint(reqBody []byte) (model.PlanLintResponse, error) {
+	var res model.PlanLintResponse
+	resp := client.post("/plans/lint", reqBody)
+	err := parseResponse(resp, &res)
+	return res, err
+}
+
+func (client *Client) postWorkLease(reqBody model.WorkLeaseRequest) (model.WorkLeaseResponse, error) {
+	var res model.WorkLeaseResponse
+	reqJSON, _ := json.Marshal(reqBody)
+	resp := client.post("/work/lease", reqJSON)
+	err := parseResponse(resp, &res)
+	return res, err
+}
+
+func (client *Client) postWorkHeartbeat(reqBody model.WorkHeartbeatRequest) (model.WorkLeaseResponse, error) {
+	var res model.WorkLeaseResponse
+	reqJSON, _ := json.Marshal(reqBody)
+	resp := client.post("/work/heartbeat", reqJSON)
+	err := parseResponse(resp, &res)
+	return res, err
+}
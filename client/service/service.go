@@ -0,0 +1,97 @@
+// Package service runs Houston stages in services written in Go. It is the equivalent of the Python client's
+// execute_service function, see docs/services.md.
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datasparq-ai/houston/client"
+	"github.com/datasparq-ai/houston/mission"
+	"github.com/datasparq-ai/houston/model"
+	"io"
+	"net/http"
+)
+
+// Func carries out a stage, given the stage's params. If it returns an error then the stage is failed.
+type Func func(params map[string]interface{}) error
+
+// Execute carries out the stage given in a trigger event, using a client created from the HOUSTON_KEY and
+// HOUSTON_BASE_URL environment variables. See ExecuteWithClient.
+func Execute(event []byte, f Func) error {
+	c := client.New("", "")
+	return ExecuteWithClient(&c, event, f)
+}
+
+// ExecuteWithClient carries out the stage given in a trigger event, which is a JSON model.TriggerMessage:
+// - the stage is started, and its upstream stages are excluded if ignore_dependencies is true
+// - if the API doesn't allow the stage to start, e.g. because the event is a duplicate, nothing else happens and no
+// error is returned
+// - f is run with the stage's params, which include the mission's params, with templates rendered by the API
+// - if f returns an error then the stage is failed, otherwise it is finished, and its downstream stages are excluded
+// if ignore_dependants is true
+// - the stages that can run next are triggered
+//
+// The error returned by f is returned once the next stages have been triggered.
+func ExecuteWithClient(c *client.Client, event []byte, f Func) error {
+	var message model.TriggerMessage
+	if err := json.Unmarshal(event, &message); err != nil {
+		return fmt.Errorf("couldn't parse trigger event: %v", err)
+	}
+	if message.MissionId == "" || message.Stage == "" {
+		return fmt.Errorf("trigger event must give the mission_id and stage to run")
+	}
+
+	res, err := c.StartStage(message.MissionId, message.Stage, message.IgnoreDependencies)
+	var stageChangeError *mission.StageChangeError
+	var completedError *mission.CompletedError
+	if errors.As(err, &stageChangeError) || errors.As(err, &completedError) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	params := res.Params
+	if params == nil {
+		params = make(map[string]interface{})
+	}
+
+	stageErr := f(params)
+	if stageErr != nil {
+		stageError := model.MissionStageError{Message: stageErr.Error(), Type: fmt.Sprintf("%T", stageErr)}
+		res, err = c.FailStage(message.MissionId, message.Stage, &stageError)
+	} else {
+		res, err = c.FinishStage(message.MissionId, message.Stage, message.IgnoreDependants)
+	}
+	if err != nil {
+		return errors.Join(stageErr, err)
+	}
+	return errors.Join(stageErr, c.TriggerNext(message.MissionId, res))
+}
+
+// Handler returns an http.Handler that carries out the stage given in the body of each POST request it receives, so
+// that the service can be used with HTTP triggers. Requests are accepted immediately, with status 202, and the stage is
+// carried out after the response is sent, so that the service that triggered it isn't kept waiting.
+func Handler(c *client.Client, f Func) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "trigger events must be sent in a POST request", http.StatusMethodNotAllowed)
+			return
+		}
+		event, err := io.ReadAll(r.Body)
+		var message model.TriggerMessage
+		if err == nil {
+			err = json.Unmarshal(event, &message)
+		}
+		if err != nil {
+			http.Error(w, "couldn't parse trigger event: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+
+		go func() {
+			if err := ExecuteWithClient(c, event, f); err != nil {
+				fmt.Printf("Stage '%v' in mission '%v' failed: %v\n", message.Stage, message.MissionId, err)
+			}
+		}()
+	})
+}
@@ -0,0 +1,135 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datasparq-ai/houston/mission"
+	"github.com/datasparq-ai/houston/model"
+	"net/http"
+	"time"
+)
+
+// ServiceAuth holds the credentials used to trigger a service that requires auth, which depends on the service's
+// trigger, see docs/service_trigger_methods.md.
+type ServiceAuth struct {
+	Token    string // bearer auth
+	Username string // basic auth
+	Password string // basic auth
+	Key      string // apikey auth
+	Name     string // name of the header used for apikey auth, defaults to 'X-API-KEY'
+}
+
+// triggerTimeout is how long to wait for a service to accept a trigger. Services should respond immediately and run
+// the stage asynchronously.
+const triggerTimeout = 10 * time.Second
+
+// ErrWaitingForWorker is returned instead of triggering a stage whose service has an exec trigger, because these stages
+// are leased by workers, see RunNextStage.
+var ErrWaitingForWorker = errors.New("stage is waiting for a worker")
+
+// Trigger triggers the service that runs a stage, using the trigger defined for the service in the mission's plan.
+// Returns ErrWaitingForWorker if the stage is run by a worker, in which case nothing was triggered.
+func (client *Client) Trigger(missionId string, stage string) error {
+	m, err := client.getMission(missionId)
+	if err != nil {
+		return err
+	}
+	return client.trigger(&m, stage, false)
+}
+
+// TriggerNext triggers the services of the stages that can be run next, given the response from updating a stage in
+// the mission. Every stage is triggered even if some fail, and all errors are returned together. Stages that are run
+// by workers are left for them.
+func (client *Client) TriggerNext(missionId string, response model.MissionStageStateUpdateResponse) error {
+	if len(response.Next) == 0 {
+		return nil
+	}
+	m, err := client.getMission(missionId)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, stage := range response.Next {
+		if err := client.trigger(&m, stage, false); !errors.Is(err, ErrWaitingForWorker) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// getMission gets a mission with its graph, so that its stages can be navigated.
+func (client *Client) getMission(missionId string) (mission.Mission, error) {
+	var missionJSON json.RawMessage
+	resp := client.get("/missions/" + missionId)
+	if err := parseResponse(resp, &missionJSON); err != nil {
+		return mission.Mission{}, err
+	}
+	return mission.NewFromJSON(missionJSON)
+}
+
+func (client *Client) trigger(m *mission.Mission, stage string, ignoreDependencies bool) error {
+	s, err := m.GetStage(stage)
+	if err != nil {
+		return err
+	}
+	trigger, _ := m.ServiceTrigger(stage)
+	if trigger == nil {
+		return fmt.Errorf("stage '%v' can't be triggered because service '%v' has no trigger", stage, s.Service)
+	}
+	message := model.TriggerMessage{Plan: m.Name, MissionId: m.Id, Stage: stage, IgnoreDependencies: ignoreDependencies}
+	switch trigger["method"] {
+	case "http":
+		return client.triggerHTTP(s.Service, trigger, message)
+	case "exec":
+		return ErrWaitingForWorker
+	}
+	return fmt.Errorf("trigger method '%v' of service '%v' is not supported by the Go client", trigger["method"], s.Service)
+}
+
+// triggerHTTP sends a trigger message to a service in an HTTP POST request. Any response other than 2xx is an error.
+func (client *Client) triggerHTTP(service string, trigger mission.Trigger, message model.TriggerMessage) error {
+	url, _ := trigger["url"].(string)
+	if url == "" {
+		return fmt.Errorf("service '%v' has an http trigger without a url", service)
+	}
+	body, _ := json.Marshal(message)
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	if method, ok := trigger["auth"]; ok {
+		auth, ok := client.ServiceAuth[service]
+		if !ok {
+			return fmt.Errorf("service '%v' requires %v auth, but no credentials were given for it", service, method)
+		}
+		switch method {
+		case "bearer":
+			req.Header.Set("Authorization", "Bearer "+auth.Token)
+		case "basic":
+			req.SetBasicAuth(auth.Username, auth.Password)
+		case "apikey":
+			name := auth.Name
+			if name == "" {
+				name = "X-API-KEY"
+			}
+			req.Header.Set(name, auth.Key)
+		default:
+			return fmt.Errorf("auth '%v' of service '%v' is not supported; must be one of bearer, basic, or apikey", method, service)
+		}
+	}
+
+	httpClient := &http.Client{Timeout: triggerTimeout}
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("service '%v' responded to the trigger for stage '%v' with status %v", service, message.Stage, res.Status)
+	}
+	return nil
+}
@@ -3,6 +3,7 @@ package client
 import (
 	"encoding/json"
 	"fmt"
+	"github.com/datasparq-ai/houston/mission"
 	"github.com/datasparq-ai/houston/model"
 	"io"
 	"net/http"
@@ -32,19 +33,30 @@ func handleErrorResponse(responseBody []byte) error {
 	case 470:
 		err = &model.KeyNotFoundError{}
 	case http.StatusNotFound:
-		// extract plan name from error message
+		// extract plan or mission name from error message
+		name := ""
 		if strings.Count(errorResponse.Message, "'") == 2 {
-			planName := errorResponse.Message[strings.Index(errorResponse.Message, "'")+1 : strings.LastIndex(errorResponse.Message, "'")]
-			err = &model.PlanNotFoundError{PlanName: planName}
+			name = errorResponse.Message[strings.Index(errorResponse.Message, "'")+1 : strings.LastIndex(errorResponse.Message, "'")]
+		}
+		if strings.HasPrefix(errorResponse.Message, "Mission ") {
+			err = &model.MissionNotFoundError{MissionId: name}
 		} else {
-			err = &model.PlanNotFoundError{}
+			err = &model.PlanNotFoundError{PlanName: name}
 		}
 	case http.StatusForbidden:
 		err = &model.BadCredentialsError{}
 	case http.StatusInternalServerError:
 		err = &model.InternalError{}
 	default:
-		err = fmt.Errorf(errorResponse.Message)
+		// stage changes that aren't allowed are identified so that services can ignore duplicate triggers
+		switch errorResponse.Type {
+		case "mission.StageChangeError":
+			err = &mission.StageChangeError{Detail: strings.TrimPrefix(errorResponse.Message, "invalid state change: ")}
+		case "mission.CompletedError":
+			err = &mission.CompletedError{}
+		default:
+			err = fmt.Errorf(errorResponse.Message)
+		}
 	}
 	return err
 }
@@ -82,7 +94,7 @@ func HandleCommandLineError(err error) {
 			errorText + err.Error() +
 				" The API key provided with the 'HOUSTON_KEY' environment variable does not exist on this server." +
 				" See the docs for a guide on creating keys: https://github.com/datasparq-ai/houston/blob/main/docs/keys.md" + end)
-	case *model.PlanNotFoundError:
+	case *model.PlanNotFoundError, *mission.PlanValidationError, *mission.StageChangeError, *mission.CompletedError:
 		fmt.Println(errorText + err.Error() + end)
 	case *json.SyntaxError:
 		fmt.Println(
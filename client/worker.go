@@ -0,0 +1,250 @@
+package client
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/datasparq-ai/houston/mission"
+	"github.com/datasparq-ai/houston/model"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stderrTail is how much of a command's stderr is kept to be sent as the stack of the stage's error if it fails.
+const stderrTail = 4096
+
+// RunNextStage leases a stage that is ready to run and is run by one of the services given, then runs the command
+// given by the service's exec trigger as a subprocess. The command's output is streamed to stdout and stderr, prefixed
+// with the name of the stage, and the lease is renewed while the command runs. The stage is finished if the command
+// exits with status 0, otherwise it is failed, and the stages that can run next are triggered. Returns false if no
+// stages were ready.
+func (client *Client) RunNextStage(worker string, services []string, leaseDuration string) (bool, error) {
+	res, err := client.LeaseStage(worker, services, leaseDuration)
+	if err != nil || res.Lease == nil {
+		return false, err
+	}
+	lease := res.Lease
+	fmt.Printf("Running stage '%v' of mission '%v'\n", lease.Stage, lease.MissionId)
+
+	m, err := client.getMission(lease.MissionId)
+	if err != nil {
+		return true, err
+	}
+	trigger, _ := m.ServiceTrigger(lease.Stage)
+	cmd, err := execCommand(trigger, lease)
+	if err != nil {
+		// the command can't be run, so there's no use retrying the stage
+		retryable := false
+		stageError := model.MissionStageError{Message: err.Error(), Type: "ExecError", Retryable: &retryable}
+		next, failErr := client.FailLeasedStage(worker, lease.MissionId, lease.Stage, &stageError)
+		if failErr != nil {
+			return true, errors.Join(err, failErr)
+		}
+		return true, errors.Join(err, client.TriggerNext(lease.MissionId, next))
+	}
+
+	stageErr, lostLease := client.runCommand(cmd, worker, lease, leaseDuration)
+	if lostLease != nil {
+		// the stage may have been returned to ready and leased to another worker, so it mustn't be updated
+		return true, fmt.Errorf("stopped stage '%v' because its lease couldn't be renewed: %v", lease.Stage, lostLease)
+	}
+
+	var next model.MissionStageStateUpdateResponse
+	if stageErr != nil {
+		fmt.Printf("Stage '%v' failed: %v\n", lease.Stage, stageErr.Message)
+		next, err = client.FailLeasedStage(worker, lease.MissionId, lease.Stage, stageErr)
+	} else {
+		fmt.Printf("Stage '%v' finished\n", lease.Stage)
+		next, err = client.FinishLeasedStage(worker, lease.MissionId, lease.Stage)
+	}
+	if err != nil {
+		return true, err
+	}
+	return true, client.TriggerNext(lease.MissionId, next)
+}
+
+// runCommand runs the command for a leased stage and renews the lease until it exits, at a third of the lease's
+// duration. If the lease can't be renewed then the command is killed and the error from the API is returned as
+// lostLease. Otherwise, stageErr describes the failure of the command, or is nil if it exited with status 0.
+func (client *Client) runCommand(cmd *exec.Cmd, worker string, lease *model.WorkLease, leaseDuration string) (stageErr *model.MissionStageError, lostLease error) {
+	tail := &tailBuffer{max: stderrTail}
+	stdout := &prefixWriter{w: os.Stdout, prefix: "[" + lease.Stage + "] "}
+	stderr := &prefixWriter{w: os.Stderr, prefix: "[" + lease.Stage + "] "}
+	cmd.Stdout = stdout
+	cmd.Stderr = io.MultiWriter(stderr, tail)
+
+	if err := cmd.Start(); err != nil {
+		retryable := false // e.g. the command doesn't exist
+		return &model.MissionStageError{Message: err.Error(), Type: "ExecError", Retryable: &retryable}, nil
+	}
+
+	interval := time.Until(lease.Expiry) / 3
+	if interval <= 0 {
+		interval = time.Second
+	}
+	done := make(chan struct{})
+	var heartbeat sync.WaitGroup
+	heartbeat.Add(1)
+	go func() {
+		defer heartbeat.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if _, err := client.RenewLease(worker, lease.MissionId, lease.Stage, leaseDuration); err != nil {
+					lostLease = err
+					cmd.Process.Kill()
+					return
+				}
+			}
+		}
+	}()
+
+	err := cmd.Wait()
+	close(done)
+	heartbeat.Wait()
+	stdout.Flush()
+	stderr.Flush()
+	if lostLease != nil || err == nil {
+		return nil, lostLease
+	}
+
+	stageErr = &model.MissionStageError{Message: err.Error(), Type: "ExecError", Stack: tail.String()}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && exitErr.ExitCode() >= 0 {
+		stageErr.Message = fmt.Sprintf("command exited with status %v", exitErr.ExitCode())
+		stageErr.Type = "ExitError"
+	}
+	return stageErr, nil
+}
+
+// execCommand creates the command given by an exec trigger to run a leased stage. The stage's params are given to the
+// command as environment variables named HOUSTON_PARAM_<NAME>, or as a JSON object on stdin if the trigger's params
+// attribute is 'stdin'. The variables HOUSTON_PLAN, HOUSTON_MISSION_ID, and HOUSTON_STAGE are always set.
+func execCommand(trigger mission.Trigger, lease *model.WorkLease) (*exec.Cmd, error) {
+	if trigger["method"] != "exec" {
+		return nil, fmt.Errorf("service '%v' can't be run by a worker because it doesn't have an exec trigger", lease.Service)
+	}
+	command, _ := trigger["command"].(string)
+	if command == "" {
+		return nil, fmt.Errorf("service '%v' has an exec trigger without a command", lease.Service)
+	}
+	var args []string
+	if list, ok := trigger["args"].([]interface{}); ok {
+		for _, a := range list {
+			args = append(args, fmt.Sprint(a))
+		}
+	}
+
+	cmd := exec.Command(command, args...)
+	if workdir, ok := trigger["workdir"].(string); ok {
+		cmd.Dir = workdir
+	}
+	cmd.Env = append(os.Environ(),
+		"HOUSTON_PLAN="+lease.Plan,
+		"HOUSTON_MISSION_ID="+lease.MissionId,
+		"HOUSTON_STAGE="+lease.Stage,
+	)
+	if env, ok := trigger["env"].(map[string]interface{}); ok {
+		for name, value := range env {
+			cmd.Env = append(cmd.Env, name+"="+fmt.Sprint(value))
+		}
+	}
+
+	switch trigger["params"] {
+	case nil, "env":
+		for name, value := range lease.Params {
+			cmd.Env = append(cmd.Env, paramEnvName(name)+"="+paramEnvValue(value))
+		}
+	case "stdin":
+		params := lease.Params
+		if params == nil {
+			params = make(map[string]interface{})
+		}
+		paramsJSON, _ := json.Marshal(params)
+		cmd.Stdin = bytes.NewReader(paramsJSON)
+	default:
+		return nil, fmt.Errorf("params '%v' of service '%v' is not supported; must be one of env or stdin", trigger["params"], lease.Service)
+	}
+	return cmd, nil
+}
+
+// paramEnvName is the name of the environment variable used for a param, e.g. 'file-name' becomes
+// HOUSTON_PARAM_FILE_NAME.
+func paramEnvName(param string) string {
+	return "HOUSTON_PARAM_" + strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, param)
+}
+
+// paramEnvValue is the value of the environment variable used for a param. Strings are used as they are, and
+// everything else is encoded as JSON.
+func paramEnvValue(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	valueJSON, _ := json.Marshal(value)
+	return string(valueJSON)
+}
+
+// prefixWriter writes each line of a command's output with a prefix, so that the output of stages can be told apart.
+// Incomplete lines are held until they are completed or Flush is called.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+	buf    []byte
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	p.buf = append(p.buf, b...)
+	for {
+		i := bytes.IndexByte(p.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if _, err := fmt.Fprintf(p.w, "%s%s", p.prefix, p.buf[:i+1]); err != nil {
+			return len(b), err
+		}
+		p.buf = p.buf[i+1:]
+	}
+	return len(b), nil
+}
+
+func (p *prefixWriter) Flush() {
+	if len(p.buf) > 0 {
+		fmt.Fprintf(p.w, "%s%s\n", p.prefix, p.buf)
+		p.buf = nil
+	}
+}
+
+// tailBuffer keeps the last max bytes written to it.
+type tailBuffer struct {
+	max  int
+	data []byte
+}
+
+func (t *tailBuffer) Write(b []byte) (int, error) {
+	t.data = append(t.data, b...)
+	if len(t.data) > t.max {
+		t.data = t.data[len(t.data)-t.max:]
+	}
+	return len(b), nil
+}
+
+func (t *tailBuffer) String() string {
+	return string(t.data)
+}
@@ -71,7 +71,7 @@ func demo(createCmd *cobra.Command) {
 	fmt.Printf(">>> %[1]vcat%[2]v \u001B[1mpath/to/plan.json%[2]v\n", s, e)
 	fmt.Println(string(planBytes))
 	fmt.Printf(">>> %[1]vhouston save%[2]v \u001B[1m-p path/to/plan.json%[2]v\n", s, e)
-	err = a.SavePlan("demo", plan)
+	err = a.SavePlan("demo", plan, "demo")
 
 	if err != nil {
 		fmt.Println(err)
@@ -7,6 +7,7 @@ import (
 	"github.com/datasparq-ai/houston/client"
 	"github.com/spf13/cobra"
 	"strings"
+	"time"
 )
 
 func main() {
@@ -111,6 +112,74 @@ func main() {
 			return
 		}())
 
+		rootCmd.AddCommand(func() (createCmd *cobra.Command) {
+			var plan string
+			createCmd = &cobra.Command{
+				Use:   "lint",
+				Short: "Check a plan for problems without saving it",
+				Run: func(c *cobra.Command, args []string) {
+					err := client.Lint(plan)
+					if err != nil {
+						client.HandleCommandLineError(err)
+					}
+				},
+			}
+			createCmd.Flags().StringVarP(&plan, "plan", "p", "", "File path of the plan to check.")
+			createCmd.MarkFlagRequired("plan")
+			return
+		}())
+
+		rootCmd.AddCommand(func() (createCmd *cobra.Command) {
+			var plan string
+			var missionId string
+			var format string
+			var output string
+			createCmd = &cobra.Command{
+				Use:   "graph",
+				Short: "Export the graph of a plan or mission as DOT, Mermaid, or SVG",
+				Run: func(c *cobra.Command, args []string) {
+					err := client.Graph(plan, missionId, format, output)
+					if err != nil {
+						client.HandleCommandLineError(err)
+					}
+				},
+			}
+			createCmd.Flags().StringVarP(&plan, "plan", "p", "", "Name of the saved plan to export")
+			createCmd.Flags().StringVarP(&missionId, "mission-id", "m", "", "ID of the mission to export, with stages coloured by state")
+			createCmd.Flags().StringVarP(&format, "format", "f", "svg", "One of 'dot', 'mermaid', or 'svg'")
+			createCmd.Flags().StringVarP(&output, "output", "o", "", "File to write the graph to. If not provided, the graph is printed")
+			createCmd.MarkFlagsOneRequired("plan", "mission-id")
+			createCmd.MarkFlagsMutuallyExclusive("plan", "mission-id")
+			return
+		}())
+
+		rootCmd.AddCommand(func() (createCmd *cobra.Command) {
+			var plan string
+			var missionId string
+			var stage string
+			var upstream bool
+			var depth int
+			createCmd = &cobra.Command{
+				Use:   "impact",
+				Short: "List the stages affected by a stage, or the stages it depends on",
+				Run: func(c *cobra.Command, args []string) {
+					err := client.Impact(plan, missionId, stage, upstream, depth)
+					if err != nil {
+						client.HandleCommandLineError(err)
+					}
+				},
+			}
+			createCmd.Flags().StringVarP(&plan, "plan", "p", "", "Name of the saved plan")
+			createCmd.Flags().StringVarP(&missionId, "mission-id", "m", "", "ID of a mission, to show the state of each stage")
+			createCmd.Flags().StringVarP(&stage, "stage", "s", "", "Name of the stage")
+			createCmd.MarkFlagRequired("stage")
+			createCmd.Flags().BoolVar(&upstream, "upstream", false, "List the stages that the stage depends on instead of the stages that depend on it")
+			createCmd.Flags().IntVarP(&depth, "depth", "d", 0, "Maximum number of links to follow. If not provided, there is no limit")
+			createCmd.MarkFlagsOneRequired("plan", "mission-id")
+			createCmd.MarkFlagsMutuallyExclusive("plan", "mission-id")
+			return
+		}())
+
 		rootCmd.AddCommand(func() (createCmd *cobra.Command) {
 			var plan string
 			var missionId = ""
@@ -118,6 +187,7 @@ func main() {
 			var exclude = ""
 			var skip = ""
 			var params = ""
+			var dryRun = false
 			createCmd = &cobra.Command{
 				Use:   "start",
 				Short: "Create a new mission and trigger the first stage(s)",
@@ -129,11 +199,15 @@ func main() {
 							client.HandleCommandLineError(err)
 						}
 					}
-					err := client.Start(plan, missionId,
-						strings.Split(strings.Replace(stages, " ", "", -1), ","),
-						strings.Split(strings.Replace(exclude, " ", "", -1), ","),
-						strings.Split(strings.Replace(skip, " ", "", -1), ","),
-						parsedParams)
+					stageList := strings.Split(strings.Replace(stages, " ", "", -1), ",")
+					excludeList := strings.Split(strings.Replace(exclude, " ", "", -1), ",")
+					skipList := strings.Split(strings.Replace(skip, " ", "", -1), ",")
+					var err error
+					if dryRun {
+						err = client.DryRun(plan, stageList, excludeList, skipList, parsedParams)
+					} else {
+						err = client.Start(plan, missionId, stageList, excludeList, skipList, parsedParams)
+					}
 					if err != nil {
 						client.HandleCommandLineError(err)
 					}
@@ -146,6 +220,31 @@ func main() {
 			createCmd.Flags().StringVarP(&exclude, "exclude", "i", "", "Comma separated list of stage names to be excluded in the new mission")
 			createCmd.Flags().StringVarP(&skip, "skip", "k", "", "Comma separated list of stage names to be skipped in the new mission")
 			createCmd.Flags().StringVar(&params, "params", "", "Mission parameters as a JSON string")
+			createCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Show the order that stages would run in and the critical path, without starting a mission. \nThe plan must be saved")
+			return
+		}())
+
+		rootCmd.AddCommand(func() (createCmd *cobra.Command) {
+			var name string
+			var services string
+			var poll time.Duration
+			var lease string
+			createCmd = &cobra.Command{
+				Use:   "worker",
+				Short: "Run stages of services with exec triggers as subprocesses on this machine",
+				Run: func(c *cobra.Command, args []string) {
+					serviceList := strings.Split(strings.Replace(services, " ", "", -1), ",")
+					err := client.Worker(name, serviceList, poll, lease)
+					if err != nil {
+						client.HandleCommandLineError(err)
+					}
+				},
+			}
+			createCmd.Flags().StringVarP(&name, "name", "n", "", "Name of the worker, which is shown in missions. Defaults to the hostname")
+			createCmd.Flags().StringVarP(&services, "services", "s", "", "Comma separated list of services with exec triggers to run. \nOnly the commands of these services are run by the worker")
+			createCmd.Flags().DurationVar(&poll, "poll", 5*time.Second, "How long to wait before looking for stages again when none are ready")
+			createCmd.Flags().StringVar(&lease, "lease", "", "Duration of the lease on each stage, e.g. '5m', which is renewed while the stage runs. \nDefaults to the API's lease_duration")
+			createCmd.MarkFlagRequired("services")
 			return
 		}())
 
@@ -7,10 +7,14 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/datasparq-ai/houston/api"
 	"github.com/datasparq-ai/houston/client"
+	"github.com/datasparq-ai/houston/client/service"
 	"github.com/datasparq-ai/houston/model"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"strings"
 	"testing"
@@ -201,6 +205,232 @@ func Test_SavePlan(t *testing.T) {
 
 }
 
+func Test_LintPlan(t *testing.T) {
+	c := client.New(testKeyId, "")
+	res, err := c.LintPlan(`{"name": "test-plan-lint", "services": [{"name": "svc"}, {"name": "unused"}], "stages": [
+		{"name": "a", "service": "svc", "downstream": ["b", "c"]},
+		{"name": "b", "service": "svc", "downstream": ["c"]},
+		{"name": "c", "service": "svc", "upstream": ["b"]}]}`)
+	if err != nil {
+		t.Fatalf("Couldn't lint plan: %v", err)
+	}
+	if !res.Valid {
+		t.Fatalf("Plan with only warnings should be valid, got %v", res.Problems)
+	}
+	codes := make(map[string]bool)
+	for _, p := range res.Problems {
+		codes[p.Code] = true
+	}
+	if !codes["redundant_link"] || !codes["duplicate_link"] || !codes["unused_service"] {
+		t.Fatalf("Expected redundant_link, duplicate_link and unused_service warnings, got %v", res.Problems)
+	}
+
+	res, err = c.LintPlan(`{"name": "test-plan-lint", "stages": [{"name": "a", "upstream": ["missing"]}]}`)
+	if err != nil || res.Valid {
+		t.Fatalf("Plan with an undefined upstream stage should not be valid")
+	}
+
+	if _, err := c.GetPlan("test-plan-lint"); err == nil {
+		t.Fatalf("Linted plans should not be saved")
+	}
+}
+
+func Test_RelatedStages(t *testing.T) {
+	c := client.New(testKeyId, "")
+	err := c.SavePlan("tests/test_plan.json")
+	if err != nil {
+		t.Fatalf("Plan should be saved without error")
+	}
+
+	res, err := c.GetPlanRelatedStages("test-plan", "stage-1", "downstream", 0)
+	if err != nil || len(res.Stages) == 0 || res.Stages[0].Depth != 1 {
+		t.Fatalf("Expected stages downstream of stage-1, got %v: %v", err, res)
+	}
+
+	mission, _ := c.CreateMission("test-plan", "Test_RelatedStages", nil)
+	res, err = c.GetMissionRelatedStages(mission.Id, res.Stages[0].Name, "upstream", 1)
+	if err != nil || len(res.Stages) != 1 || res.Stages[0].Name != "stage-1" || res.Stages[0].State != "ready" {
+		t.Fatalf("Expected stage-1 to be upstream, with its state, got %v: %v", err, res)
+	}
+
+	if _, err := c.GetPlanRelatedStages("test-plan", "stage-1", "downstream", -1); err == nil {
+		t.Fatalf("Negative depths should not be allowed")
+	}
+	if _, err := c.GetMissionRelatedStages("missing", "stage-1", "upstream", 1); err == nil {
+		t.Fatalf("Missions that don't exist should return an error")
+	} else if _, ok := err.(*model.MissionNotFoundError); !ok {
+		t.Fatalf("Missions that don't exist should return a MissionNotFoundError, got %T: %v", err, err)
+	}
+}
+
+func Test_Trigger(t *testing.T) {
+	messages := make(chan model.TriggerMessage, 10)
+	service := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, _ := r.BasicAuth()
+		if r.Header.Get("Authorization") != "Bearer token" && r.Header.Get("X-Service-Key") != "key" && (username != "user" || password != "pass") {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var message model.TriggerMessage
+		json.NewDecoder(r.Body).Decode(&message)
+		messages <- message
+	}))
+	defer service.Close()
+
+	plan := fmt.Sprintf(`{"name": "test-plan-trigger", "services": [
+		{"name": "bearer", "trigger": {"method": "http", "url": "%[1]v", "auth": "bearer"}},
+		{"name": "basic", "trigger": {"method": "http", "url": "%[1]v", "auth": "basic"}},
+		{"name": "apikey", "trigger": {"method": "http", "url": "%[1]v", "auth": "apikey"}},
+		{"name": "pubsub", "trigger": {"method": "pubsub", "topic": "topic"}}
+	], "stages": [
+		{"name": "extract", "service": "bearer"},
+		{"name": "load", "service": "basic", "upstream": ["extract"]},
+		{"name": "report", "service": "apikey", "upstream": ["extract"]},
+		{"name": "publish", "service": "pubsub", "upstream": ["load"]}
+	]}`, service.URL)
+
+	c := client.New(testKeyId, "")
+	c.ServiceAuth = map[string]client.ServiceAuth{
+		"bearer": {Token: "token"},
+		"basic":  {Username: "user", Password: "pass"},
+		"apikey": {Key: "key", Name: "X-Service-Key"},
+	}
+	res, err := c.CreateMission(plan, "Test_Trigger", nil)
+	if err != nil {
+		t.Fatalf("Could not create mission: %v", err)
+	}
+
+	if err := c.Trigger(res.Id, "extract"); err != nil {
+		t.Fatalf("Failed to trigger stage with bearer auth: %v", err)
+	}
+	if message := <-messages; message.Plan != "test-plan-trigger" || message.MissionId != res.Id || message.Stage != "extract" {
+		t.Fatalf("Unexpected trigger message: %v", message)
+	}
+
+	c.StartStage(res.Id, "extract", false)
+	next, _ := c.FinishStage(res.Id, "extract", false)
+	if err := c.TriggerNext(res.Id, next); err != nil {
+		t.Fatalf("Failed to trigger next stages with basic and apikey auth: %v", err)
+	}
+	triggered := []string{(<-messages).Stage, (<-messages).Stage}
+	if !(triggered[0] == "load" && triggered[1] == "report" || triggered[0] == "report" && triggered[1] == "load") {
+		t.Fatalf("Expected load and report to be triggered, got %v", triggered)
+	}
+
+	if err := c.Trigger(res.Id, "publish"); err == nil || !strings.Contains(err.Error(), "not supported") {
+		t.Fatalf("Unsupported trigger methods should return an error, got %v", err)
+	}
+	c.ServiceAuth = nil
+	if err := c.Trigger(res.Id, "load"); err == nil {
+		t.Fatalf("Services that require auth should not be triggered without credentials")
+	}
+}
+
+func Test_Service(t *testing.T) {
+	c := client.New(testKeyId, "")
+	ran := make(chan string, 10)
+	run := func(params map[string]interface{}) error {
+		ran <- fmt.Sprint(params["table"])
+		if params["table"] == "bad" {
+			return fmt.Errorf("table not found")
+		}
+		return nil
+	}
+	loader := httptest.NewServer(service.Handler(&c, run))
+	defer loader.Close()
+
+	plan := fmt.Sprintf(`{"name": "test-plan-service", "services": [
+		{"name": "worker"},
+		{"name": "loader", "trigger": {"method": "http", "url": "%v"}}
+	], "params": {"table": "sales"}, "stages": [
+		{"name": "extract", "service": "worker"},
+		{"name": "load", "service": "loader", "upstream": ["extract"], "params": {"table": "orders"}},
+		{"name": "audit", "service": "worker", "upstream": ["load"], "params": {"table": "bad"}}
+	]}`, loader.URL)
+	res, err := c.CreateMission(plan, "Test_Service", nil)
+	if err != nil {
+		t.Fatalf("Could not create mission: %v", err)
+	}
+
+	event := []byte(`{"plan": "test-plan-service", "mission_id": "Test_Service", "stage": "extract"}`)
+	if err := service.ExecuteWithClient(&c, event, run); err != nil {
+		t.Fatalf("Failed to execute stage: %v", err)
+	}
+	if table := <-ran; table != "sales" {
+		t.Fatalf("Stage should be run with the mission's params, got %v", table)
+	}
+	if err := service.ExecuteWithClient(&c, event, run); err != nil {
+		t.Fatalf("Duplicate events should be ignored, got %v", err)
+	}
+
+	// load is triggered through the HTTP handler once extract has finished, and then audit can be run
+	if table := <-ran; table != "orders" {
+		t.Fatalf("Only load should run after extract, got %v", table)
+	}
+	for i := 0; i < 100; i++ {
+		if m, _ := c.GetMission(res.Id); m.Stages[1].Name == "load" && m.Stages[1].State.String() == "finished" {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	event = []byte(`{"plan": "test-plan-service", "mission_id": "Test_Service", "stage": "audit", "ignore_dependants": true}`)
+	if err := service.ExecuteWithClient(&c, event, run); err == nil || !strings.Contains(err.Error(), "table not found") {
+		t.Fatalf("The error from the stage should be returned, got %v", err)
+	}
+	m, _ := c.GetMission(res.Id)
+	if m.Stages[2].State.String() != "failed" || m.Stages[2].Error == nil || m.Stages[2].Error.Message != "table not found" {
+		t.Fatalf("Stage should be failed with the error given, got %v", m.Stages[2].State)
+	}
+
+	if err := service.ExecuteWithClient(&c, []byte(`{"plan": "test-plan-service"}`), run); err == nil {
+		t.Fatalf("Events without a mission and stage should return an error")
+	}
+}
+
+func Test_Worker(t *testing.T) {
+	c := client.New(testKeyId, "")
+	plan := `{"name": "test-plan-worker", "services": [
+		{"name": "exec-check", "trigger": {"method": "exec", "command": "sh", "args": ["-c", "test \"$HOUSTON_PARAM_TABLE\" = sales && test \"$HOUSTON_STAGE\" = check && test \"$GREETING\" = hello"], "env": {"GREETING": "hello"}}},
+		{"name": "exec-read", "trigger": {"method": "exec", "command": "sh", "args": ["-c", "grep -q '\"table\":\"orders\"'"], "params": "stdin"}},
+		{"name": "exec-broken", "trigger": {"method": "exec", "command": "sh", "args": ["-c", "echo table not found >&2; exit 3"]}}
+	], "params": {"table": "sales"}, "stages": [
+		{"name": "check", "service": "exec-check"},
+		{"name": "read", "service": "exec-read", "upstream": ["check"], "params": {"table": "orders"}},
+		{"name": "broken", "service": "exec-broken", "upstream": ["read"]}
+	]}`
+	res, err := c.CreateMission(plan, "Test_Worker", nil)
+	if err != nil {
+		t.Fatalf("Could not create mission: %v", err)
+	}
+	if err := c.Trigger(res.Id, "check"); !errors.Is(err, client.ErrWaitingForWorker) {
+		t.Fatalf("Stages of exec services should be left for workers, got %v", err)
+	}
+
+	services := []string{"exec-check", "exec-read", "exec-broken"}
+	for i := 0; i < 3; i++ {
+		ran, err := c.RunNextStage("test-worker", services, "1m")
+		if err != nil {
+			t.Fatalf("Worker failed to run stage: %v", err)
+		}
+		if !ran {
+			t.Fatalf("Worker should have run a stage on attempt %v", i)
+		}
+	}
+	if ran, _ := c.RunNextStage("test-worker", services, "1m"); ran {
+		t.Fatalf("Worker should have no stages left to run")
+	}
+
+	m, _ := c.GetMission(res.Id)
+	for i, state := range []string{"finished", "finished", "failed"} {
+		if m.Stages[i].State.String() != state {
+			t.Fatalf("Stage '%v' should be %v, got %v", m.Stages[i].Name, state, m.Stages[i].State)
+		}
+	}
+	if e := m.Stages[2].Error; e == nil || e.Message != "command exited with status 3" || !strings.Contains(e.Stack, "table not found") {
+		t.Fatalf("Failed stage should have the exit status and stderr of the command, got %v", e)
+	}
+}
+
 func Test_DeletePlan(t *testing.T) {
 	c := client.New(testKeyId, "")
 	c.SavePlan("tests/test_plan_deleted.json")
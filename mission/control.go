@@ -0,0 +1,66 @@
+package mission
+
+import (
+	"fmt"
+	"time"
+)
+
+// Cancellation records why, when, and by whom a mission was cancelled.
+type Cancellation struct {
+	Reason string    `json:"m,omitempty" name:"reason"`
+	Actor  string    `json:"b,omitempty" name:"actor"`
+	Time   time.Time `json:"t" name:"time"`
+}
+
+// Pause prevents any more stages from being started until the mission is resumed. Stages that are in progress can
+// still be finished or failed, but no next stages will be returned.
+func (m *Mission) Pause() (Response, error) {
+	if m.isComplete {
+		return Response{false, nil, true, nil, nil}, &CompletedError{}
+	}
+	if m.Paused {
+		return Response{false, nil, m.isComplete, nil, nil}, &StageChangeError{"cannot pause mission because it is already paused"}
+	}
+	m.Paused = true
+	return Response{true, []string{}, m.isComplete, nil, nil}, nil
+}
+
+// Resume allows stages to be started again after the mission was paused, and returns the stages that can be started.
+func (m *Mission) Resume() (Response, error) {
+	if m.isComplete {
+		return Response{false, nil, true, nil, nil}, &CompletedError{}
+	}
+	if !m.Paused {
+		return Response{false, nil, m.isComplete, nil, nil}, &StageChangeError{"cannot resume mission because it is not paused"}
+	}
+	m.Paused = false
+	return Response{true, m.Next(), m.isComplete, nil, nil}, nil
+}
+
+// Cancel stops the mission by excluding every stage that hasn't started yet, including failed stages, and marks the
+// mission as complete. Stages that are in progress are left as they are, but can no longer be finished or failed.
+func (m *Mission) Cancel(reason string, actor string) (Response, error) {
+	if m.isComplete {
+		return Response{false, nil, true, nil, nil}, &CompletedError{}
+	}
+	now := time.Now()
+	message := "mission was cancelled"
+	if reason != "" {
+		message += ": " + reason
+	}
+	for _, s := range m.Stages {
+		if s.State == ready || s.State == failed {
+			m.setState(s, excluded, message)
+		}
+	}
+	m.Paused = false
+	m.Cancellation = &Cancellation{reason, actor, now}
+	m.isComplete = true
+	m.End = now
+	return Response{true, []string{}, m.isComplete, nil, nil}, nil
+}
+
+// pausedError is returned when a stage is started while the mission is paused.
+func pausedError(stageName string) error {
+	return &StageChangeError{fmt.Sprintf("cannot start stage '%v' because the mission is paused - the mission must be resumed first", stageName)}
+}
@@ -0,0 +1,84 @@
+package mission
+
+import (
+	"fmt"
+	"time"
+)
+
+// Statuses of a dispatch.
+const (
+	DispatchPending   = "pending"   // the service hasn't been reached yet, and will be tried again
+	DispatchDelivered = "delivered" // the service accepted the request
+	DispatchFailed    = "failed"    // every attempt to reach the service failed
+	DispatchSkipped   = "skipped"   // the service requires auth, so the API can't trigger it
+)
+
+// Trigger describes how a service is triggered, e.g. {"method": "http", "url": "https://example.com"}.
+type Trigger map[string]interface{}
+
+// Dispatch records the outcome of the API triggering a stage's service, for services that are triggered by the API
+// instead of by clients.
+type Dispatch struct {
+	Status   string    `json:"s" name:"status"`
+	Attempts int       `json:"c" name:"attempts"`
+	Time     time.Time `json:"t" name:"time"`              // time of the last attempt
+	Message  string    `json:"m,omitempty" name:"message"` // why the last attempt failed
+}
+
+// SetDispatch stores the outcome of triggering a stage's service. Dispatches can be recorded in any state because the
+// service may have started the stage before the outcome is known.
+func (m *Mission) SetDispatch(stageName string, dispatch *Dispatch) error {
+	s, err := m.GetStage(stageName)
+	if err != nil {
+		return err
+	}
+	s.Dispatch = dispatch
+	return nil
+}
+
+// ServiceTrigger returns the trigger of the service that runs a stage, as defined in the plan, or nil if the service
+// doesn't have one.
+func (m *Mission) ServiceTrigger(stageName string) (Trigger, error) {
+	s, err := m.GetStage(stageName)
+	if err != nil {
+		return nil, err
+	}
+	return m.Triggers[s.Service], nil
+}
+
+// RetriesDue returns the failed stages whose backoff has elapsed, and which haven't been dispatched since they failed.
+// These stages only appear in the response to whichever stage update comes next, so the API must dispatch them itself.
+func (m *Mission) RetriesDue() []string {
+	var due []string
+	for _, name := range m.Next() {
+		s, _ := m.GetStage(name)
+		if s.State == failed && (s.Dispatch == nil || s.Dispatch.Time.Before(s.End)) {
+			due = append(due, name)
+		}
+	}
+	return due
+}
+
+// ValidateDispatch checks that the API can trigger the service of every stage that has an HTTP trigger. The API can't
+// provide the credentials of services that require auth, so these services can't be used while the dispatcher is
+// enabled.
+func (m *Mission) ValidateDispatch() error {
+	var ps problems
+	for i, service := range m.Services {
+		trigger := m.Triggers[service]
+		if _, ok := trigger["auth"]; ok && trigger["method"] == "http" {
+			ps.add("dispatch_auth", fmt.Sprintf("services[%v].trigger.auth", i), "", "service '%v' requires auth, so it can't be triggered by the dispatcher", service)
+		}
+	}
+	return validationError(ps)
+}
+
+func (d *Dispatch) String() string {
+	switch d.Status {
+	case DispatchFailed:
+		return fmt.Sprintf("dispatch failed after %v attempts: %v", d.Attempts, d.Message)
+	case DispatchSkipped:
+		return "dispatch skipped: " + d.Message
+	}
+	return "dispatch " + d.Status
+}
@@ -0,0 +1,179 @@
+package mission
+
+import (
+	"fmt"
+	"time"
+)
+
+// ExecutionPlan describes what would happen if a mission was run, assuming that every stage finishes successfully.
+type ExecutionPlan struct {
+	Waves             [][]string        `json:"waves"`    // stages that would be started together, in order
+	Excluded          []string          `json:"excluded"` // stages that would be excluded, including those downstream of excluded stages
+	Skipped           []string          `json:"skipped"`  // stages that would be skipped, including handlers that wouldn't run
+	Failed            []string          `json:"failed"`   // map stages that couldn't be expanded because their list isn't known yet
+	CriticalPath      []string          `json:"criticalPath"`
+	EstimatedDuration string            `json:"estimatedDuration"`   // total duration of the critical path
+	Durations         map[string]string `json:"durations,omitempty"` // estimated duration of each stage, where known
+}
+
+// DryRun simulates running the mission and returns the order that stages would be started in. The stages given in
+// exclude and skip are excluded and skipped first. If start is given then the mission starts from those stages, and
+// their upstream stages are excluded, as if they were started with dependencies ignored. Every stage is assumed to
+// finish successfully, so on_failure handlers are skipped. The critical path is the longest path through the mission
+// according to the durations given, which are typically taken from previous missions. Stages without a duration are
+// counted as taking no time. The mission is modified, so this should only be run on a mission that won't be saved.
+func (m *Mission) DryRun(start []string, exclude []string, skip []string, durations map[string]time.Duration) (*ExecutionPlan, error) {
+	for _, name := range exclude {
+		if _, err := m.ExcludeStage(name); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range skip {
+		if _, err := m.SkipStage(name); err != nil {
+			return nil, err
+		}
+	}
+
+	var wave []string
+	for _, name := range start {
+		s, err := m.GetStage(name)
+		if err != nil {
+			return nil, err
+		}
+		if s.State != ready || s.isMapStage() {
+			return nil, &StageChangeError{fmt.Sprintf("cannot start stage '%v' because it is %v", name, s.State)}
+		}
+		// see StartStage with ignoreDependencies
+		s.State = excluded
+		if err := m.excludeUpstreamRecursively(s, fmt.Sprintf("stage '%v' was started with dependencies ignored", s.Name)); err != nil {
+			return nil, err
+		}
+		s.State = ready
+		wave = append(wave, name)
+	}
+	if len(wave) == 0 {
+		wave = m.Next()
+	}
+
+	plan := &ExecutionPlan{Waves: [][]string{}, Excluded: []string{}, Skipped: []string{}, Failed: []string{}}
+	for len(wave) > 0 {
+		plan.Waves = append(plan.Waves, wave)
+		for _, name := range wave {
+			s, _ := m.GetStage(name)
+			s.State = finished
+		}
+		m.propagate()
+		wave = m.Next()
+	}
+
+	for _, s := range m.Stages {
+		switch s.State {
+		case excluded:
+			plan.Excluded = append(plan.Excluded, s.Name)
+		case skipped:
+			plan.Skipped = append(plan.Skipped, s.Name)
+		case failed:
+			plan.Failed = append(plan.Failed, s.Name)
+		}
+	}
+
+	path, total := m.criticalPath(plan.Waves, durations)
+	plan.CriticalPath = path
+	plan.EstimatedDuration = total.String()
+	for _, s := range m.Stages {
+		if d, ok := stageDuration(s, durations); ok {
+			if plan.Durations == nil {
+				plan.Durations = make(map[string]string)
+			}
+			plan.Durations[s.Name] = d.String()
+		}
+	}
+	return plan, nil
+}
+
+// FinishedDurations returns how long each finished stage took, which can be used to estimate durations in a dry run.
+func (m *Mission) FinishedDurations() map[string]time.Duration {
+	durations := make(map[string]time.Duration)
+	for _, s := range m.Stages {
+		if s.State == finished && !s.Start.IsZero() && !s.End.IsZero() {
+			durations[s.Name] = s.End.Sub(s.Start)
+		}
+	}
+	return durations
+}
+
+// stageDuration returns the duration of a stage from the durations given. Instances of map stages use the duration of
+// their map stage if their own duration isn't known.
+func stageDuration(s *Stage, durations map[string]time.Duration) (time.Duration, bool) {
+	if d, ok := durations[s.Name]; ok {
+		return d, true
+	}
+	if s.MappedFrom != "" {
+		d, ok := durations[s.MappedFrom]
+		return d, ok
+	}
+	return 0, false
+}
+
+// criticalPath finds the longest path through the stages that were run in a dry run, according to their durations.
+// If paths have the same duration then the one with the most stages is used.
+func (m *Mission) criticalPath(waves [][]string, durations map[string]time.Duration) ([]string, time.Duration) {
+	type step struct {
+		total    time.Duration
+		length   int
+		previous *Stage
+	}
+	steps := make(map[*Stage]step)
+	var end *Stage
+	longer := func(a, b step) bool {
+		return a.total > b.total || (a.total == b.total && a.length > b.length)
+	}
+
+	var done []*Stage
+	for _, wave := range waves {
+		var stages []*Stage
+		for _, name := range wave {
+			s, _ := m.GetStage(name)
+			stages = append(stages, s)
+		}
+		for _, s := range stages {
+			// a stage depends on the stages it runs after, looking through skipped stages
+			previous := m.graph.effectiveUpstream(s, true)
+			for _, e := range m.graph.handles[s] {
+				previous = append(previous, e.stage)
+			}
+			if s.Finalizer {
+				previous = append([]*Stage{}, done...)
+			}
+
+			// map stages are never started themselves, so their instances are used in their place
+			for _, p := range previous {
+				if p.isMapStage() {
+					previous = append(previous, m.instances(p)...)
+				}
+			}
+
+			best := step{}
+			for _, p := range previous {
+				if ps, ok := steps[p]; ok && longer(step{ps.total, ps.length, p}, best) {
+					best = step{ps.total, ps.length, p}
+				}
+			}
+			d, _ := stageDuration(s, durations)
+			steps[s] = step{best.total + d, best.length + 1, best.previous}
+			if end == nil || longer(steps[s], steps[end]) {
+				end = s
+			}
+		}
+		done = append(done, stages...)
+	}
+
+	if end == nil {
+		return []string{}, 0
+	}
+	var path []string
+	for s := end; s != nil; s = steps[s].previous {
+		path = append([]string{s.Name}, path...)
+	}
+	return path, steps[end].total
+}
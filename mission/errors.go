@@ -3,7 +3,8 @@ package mission
 import "fmt"
 
 type PlanValidationError struct {
-	Detail string
+	Detail   string
+	Problems []Problem // every problem found, if the plan was fully validated
 }
 
 func (e *PlanValidationError) Error() string {
@@ -0,0 +1,192 @@
+package mission
+
+import (
+	"fmt"
+	"html"
+	"strconv"
+	"strings"
+)
+
+// Formats that a mission's graph can be exported in.
+const (
+	FormatDOT     = "dot"
+	FormatMermaid = "mermaid"
+	FormatSVG     = "svg"
+)
+
+// stateColours are the fill colours of stages in exported graphs, by state.
+var stateColours = map[state]string{
+	ready:    "#ffffff",
+	started:  "#bfdbfe",
+	finished: "#bbf7d0",
+	failed:   "#fecaca",
+	excluded: "#e5e7eb",
+	skipped:  "#fef3c7",
+}
+
+// link is a link between two stages in an exported graph. Label is only given for on_success and on_failure links.
+type link struct {
+	from  *Stage
+	to    *Stage
+	label string
+}
+
+// links returns every link in the mission's graph in the order that the stages are defined.
+func (m *Mission) links() []link {
+	var links []link
+	for _, s := range m.Stages {
+		for _, d := range m.graph.down[s] {
+			links = append(links, link{s, d, ""})
+		}
+		for _, e := range m.graph.handlers[s] {
+			label := "on_success"
+			if e.on == failed {
+				label = "on_failure"
+			}
+			links = append(links, link{s, e.stage, label})
+		}
+	}
+	return links
+}
+
+// Export returns the mission's graph in the format given, which can be 'dot' (Graphviz), 'mermaid', or 'svg'. If
+// showState is true then stages are coloured by their state, otherwise all stages are shown as ready, which is used to
+// export plans.
+func (m *Mission) Export(format string, showState bool) (string, error) {
+	switch format {
+	case FormatDOT:
+		return m.dot(showState), nil
+	case FormatMermaid:
+		return m.mermaid(showState), nil
+	case FormatSVG:
+		return m.svg(showState), nil
+	}
+	return "", fmt.Errorf("graph format '%v' is not supported; must be one of %v, %v, or %v", format, FormatDOT, FormatMermaid, FormatSVG)
+}
+
+// colour gives the fill colour of a stage in an exported graph.
+func (s *Stage) colour(showState bool) string {
+	if !showState {
+		return stateColours[ready]
+	}
+	return stateColours[s.State]
+}
+
+func (m *Mission) dot(showState bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "digraph %v {\n", strconv.Quote(m.Name))
+	b.WriteString("  rankdir=LR;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fontname=\"Helvetica\"];\n")
+	for _, s := range m.Stages {
+		fmt.Fprintf(&b, "  %v [fillcolor=%v", strconv.Quote(s.Name), strconv.Quote(s.colour(showState)))
+		if showState {
+			fmt.Fprintf(&b, ", tooltip=%v", strconv.Quote(s.State.String()))
+		}
+		b.WriteString("];\n")
+	}
+	for _, l := range m.links() {
+		fmt.Fprintf(&b, "  %v -> %v", strconv.Quote(l.from.Name), strconv.Quote(l.to.Name))
+		if l.label != "" {
+			fmt.Fprintf(&b, " [style=dashed, label=%v]", strconv.Quote(l.label))
+		}
+		b.WriteString(";\n")
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func (m *Mission) mermaid(showState bool) string {
+	ids := make(map[*Stage]string, len(m.Stages))
+	var b strings.Builder
+	b.WriteString("flowchart LR\n")
+	for i, s := range m.Stages {
+		ids[s] = fmt.Sprintf("s%v", i)
+		fmt.Fprintf(&b, "  %v[\"%v\"]\n", ids[s], strings.ReplaceAll(s.Name, "\"", "#quot;"))
+	}
+	for _, l := range m.links() {
+		if l.label != "" {
+			fmt.Fprintf(&b, "  %v -. %v .-> %v\n", ids[l.from], l.label, ids[l.to])
+		} else {
+			fmt.Fprintf(&b, "  %v --> %v\n", ids[l.from], ids[l.to])
+		}
+	}
+	if showState {
+		for st := ready; st <= skipped; st++ {
+			var members []string
+			for _, s := range m.Stages {
+				if s.State == st {
+					members = append(members, ids[s])
+				}
+			}
+			if len(members) > 0 {
+				fmt.Fprintf(&b, "  classDef %v fill:%v\n", st, stateColours[st])
+				fmt.Fprintf(&b, "  class %v %v\n", strings.Join(members, ","), st)
+			}
+		}
+	}
+	return b.String()
+}
+
+// sizes used when drawing SVG graphs, in pixels. Stages are drawn at their positions, the same as in the dashboard, or
+// positioned using Layout if they don't have any.
+const (
+	svgNodeWidth  = 160
+	svgNodeHeight = 40
+	svgMargin     = 20
+)
+
+func (m *Mission) svg(showState bool) string {
+	type point struct{ x, y int }
+	positions := make(map[*Stage]point, len(m.Stages))
+	layout := m.Positions()
+	if !m.hasLayout() {
+		layout = m.Layout(nil)
+	}
+	// stages pinned by the plan's author may have negative positions, so the graph is moved to fit in the image
+	minX, minY := 0, 0
+	for _, p := range layout {
+		if p.X < minX {
+			minX = p.X
+		}
+		if p.Y < minY {
+			minY = p.Y
+		}
+	}
+	width, height := 0, 0
+	for _, s := range m.Stages {
+		p := point{svgMargin + layout[s.Name].X - minX, svgMargin + layout[s.Name].Y - minY}
+		positions[s] = p
+		if p.x+svgNodeWidth+svgMargin > width {
+			width = p.x + svgNodeWidth + svgMargin
+		}
+		if p.y+svgNodeHeight+svgMargin > height {
+			height = p.y + svgNodeHeight + svgMargin
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%v\" height=\"%v\" font-family=\"Helvetica, sans-serif\" font-size=\"14\">\n", width, height)
+	fmt.Fprintf(&b, "  <title>%v</title>\n", html.EscapeString(m.Name))
+	b.WriteString("  <defs><marker id=\"arrow\" viewBox=\"0 0 10 10\" refX=\"10\" refY=\"5\" markerWidth=\"8\" markerHeight=\"8\" orient=\"auto\"><path d=\"M 0 0 L 10 5 L 0 10 z\" fill=\"#374151\"/></marker></defs>\n")
+	for _, l := range m.links() {
+		from, to := positions[l.from], positions[l.to]
+		dash := ""
+		if l.label != "" {
+			dash = " stroke-dasharray=\"5,5\""
+		}
+		fmt.Fprintf(&b, "  <line x1=\"%v\" y1=\"%v\" x2=\"%v\" y2=\"%v\" stroke=\"#374151\"%v marker-end=\"url(#arrow)\"/>\n",
+			from.x+svgNodeWidth, from.y+svgNodeHeight/2, to.x, to.y+svgNodeHeight/2, dash)
+	}
+	for _, s := range m.Stages {
+		p := positions[s]
+		title := s.Name
+		if showState {
+			title += ": " + s.State.String()
+		}
+		fmt.Fprintf(&b, "  <g><title>%v</title>", html.EscapeString(title))
+		fmt.Fprintf(&b, "<rect x=\"%v\" y=\"%v\" width=\"%v\" height=\"%v\" rx=\"6\" fill=\"%v\" stroke=\"#374151\"/>", p.x, p.y, svgNodeWidth, svgNodeHeight, s.colour(showState))
+		fmt.Fprintf(&b, "<text x=\"%v\" y=\"%v\" text-anchor=\"middle\" dominant-baseline=\"middle\">%v</text></g>\n", p.x+svgNodeWidth/2, p.y+svgNodeHeight/2, html.EscapeString(s.Name))
+	}
+	b.WriteString("</svg>\n")
+	return b.String()
+}
@@ -0,0 +1,33 @@
+package mission
+
+import "fmt"
+
+// StageError describes why a stage failed, as reported by the service that ran it.
+type StageError struct {
+	Message   string `json:"m" name:"message"`
+	Type      string `json:"t,omitempty" name:"type"`  // e.g. the class of exception that was raised
+	Stack     string `json:"s,omitempty" name:"stack"` // a stack trace or an excerpt of the service's logs
+	Retryable *bool  `json:"r,omitempty" name:"retryable"`
+}
+
+func (e *StageError) String() string {
+	if e.Type == "" {
+		return e.Message
+	}
+	return fmt.Sprintf("%v: %v", e.Type, e.Message)
+}
+
+// SetError stores the error that caused a stage to fail. The stage must be in progress, and errors are normally given
+// when the stage is failed. The error's message is also used as the reason for the failure.
+func (m *Mission) SetError(stageName string, stageError *StageError) error {
+	s, err := m.GetStage(stageName)
+	if err != nil {
+		return err
+	}
+	if s.State != started {
+		return &StageChangeError{fmt.Sprintf("cannot set error of stage '%v' because it is %v, not started", stageName, s.State)}
+	}
+	s.Error = stageError
+	s.Reason = stageError.String()
+	return nil
+}
@@ -4,8 +4,37 @@ import "fmt"
 
 // Graph object represents the mission DAG in terms of links between stages
 type Graph struct {
-  down map[*Stage][]*Stage
-  up   map[*Stage][]*Stage
+  down     map[*Stage][]*Stage
+  up       map[*Stage][]*Stage
+  handlers map[*Stage][]edge // typed links from a stage to the stages that handle its outcome
+  handles  map[*Stage][]edge // typed links from a handler stage to the stages whose outcome it handles
+}
+
+// edge is a typed link between two stages. The stage on the other end of the link only runs if the stage on this end
+// ends in the state given by 'on', which is either finished (on_success) or failed (on_failure).
+type edge struct {
+  stage *Stage
+  on    state
+}
+
+// AddTypedLink is used to create the graph object from each on_success or on_failure link seen in the graph.
+func (g *Graph) AddTypedLink(from *Stage, to *Stage, on state) {
+  for _, e := range g.handlers[from] {
+    if e.stage.Name == to.Name && e.on == on {
+      return // prevent duplicates
+    }
+  }
+  g.handlers[from] = append(g.handlers[from], edge{to, on})
+  g.handles[to] = append(g.handles[to], edge{from, on})
+}
+
+// next returns all the stages linked downstream of a stage, including those linked by typed links.
+func (g *Graph) next(s *Stage) []*Stage {
+  next := append([]*Stage{}, g.down[s]...)
+  for _, e := range g.handlers[s] {
+    next = append(next, e.stage)
+  }
+  return next
 }
 
 // AddLink is used to create the graph object from each link seen in the graph.
@@ -18,11 +47,12 @@ func (g *Graph) AddLink(from *Stage, to *Stage) {
   }
 }
 
-// CheckForCycle recursively crawls the graph and returns true if starting stage is seen again.
+// CheckForCycle recursively crawls the graph and returns true if starting stage is seen again. Both normal and typed
+// links are followed.
 func (g *Graph) CheckForCycle(s *Stage, visited map[*Stage]bool, recursion map[*Stage]bool) bool {
   visited[s] = true
   recursion[s] = true // flag as recursive in this cycle so if we see it we know there's a cycle
-  for _, downstreamStage := range g.down[s] {
+  for _, downstreamStage := range g.next(s) {
     if !visited[downstreamStage] {
       if g.CheckForCycle(downstreamStage, visited, recursion) {
         return true
@@ -36,6 +66,36 @@ func (g *Graph) CheckForCycle(s *Stage, visited map[*Stage]bool, recursion map[*
   return false
 }
 
+// FindCycles returns every cycle in the graph as the path of stages that make up the cycle, starting and ending with
+// the same stage. Both normal and typed links are followed.
+func (g *Graph) FindCycles(stages []*Stage) [][]*Stage {
+  var cycles [][]*Stage
+  visited := make(map[*Stage]bool)
+  var path []*Stage
+  var visit func(s *Stage)
+  visit = func(s *Stage) {
+    visited[s] = true
+    path = append(path, s)
+    for _, downstreamStage := range g.next(s) {
+      for i, p := range path {
+        if p == downstreamStage { // we saw the same node again in this path --> graph is cyclic
+          cycles = append(cycles, append(append([]*Stage{}, path[i:]...), downstreamStage))
+        }
+      }
+      if !visited[downstreamStage] {
+        visit(downstreamStage)
+      }
+    }
+    path = path[:len(path)-1]
+  }
+  for _, s := range stages {
+    if !visited[s] {
+      visit(s)
+    }
+  }
+  return cycles
+}
+
 // utility used by CheckForIncontiguity to visit every stage in the graph
 func (g *Graph) visitRecursively(s *Stage, visited map[*Stage]bool) {
   if visited[s] {
@@ -48,56 +108,124 @@ func (g *Graph) visitRecursively(s *Stage, visited map[*Stage]bool) {
   for _, d := range g.down[s] {
     g.visitRecursively(d, visited)
   }
+  for _, e := range g.handlers[s] {
+    g.visitRecursively(e.stage, visited)
+  }
+  for _, e := range g.handles[s] {
+    g.visitRecursively(e.stage, visited)
+  }
   return
 }
 
 // CheckForIncontiguity returns the first stage found that can't be reached from the starting stage.
-// If the graph is contiguous then it will return nil.
+// If the graph is contiguous then it will return nil. Finalizer stages are not linked to any stages, so are ignored.
 func (g *Graph) CheckForIncontiguity(stages []*Stage) *Stage {
-  startingStage := stages[0]
+  if unreachable := g.UnreachableStages(stages); len(unreachable) > 0 {
+    return unreachable[0]
+  }
+  return nil
+}
+
+// UnreachableStages returns every stage that can't be reached from the starting stage, in the order given.
+func (g *Graph) UnreachableStages(stages []*Stage) []*Stage {
+  var startingStage *Stage
   visited := make(map[*Stage]bool)
   for _, s := range stages {
+    if s.Finalizer {
+      continue
+    }
+    if startingStage == nil {
+      startingStage = s
+    }
     visited[s] = false
   }
+  if startingStage == nil {
+    return nil
+  }
   g.visitRecursively(startingStage, visited) // ends when all stages have been visited
-  for s, v := range visited {
-    if !v {
-      return s // a stage was not visited
+  var unreachable []*Stage
+  for _, s := range stages {
+    if v, ok := visited[s]; ok && !v {
+      unreachable = append(unreachable, s) // a stage was not visited
     }
   }
-  return nil
+  return unreachable
+}
+
+// RedundantLinks returns every link between two stages that is implied by a longer path between them, i.e. the links
+// that would be removed by a transitive reduction of the graph. Each link is given as [from, to]. Only normal links are
+// followed, and the graph must not be cyclic.
+func (g *Graph) RedundantLinks(stages []*Stage) [][2]*Stage {
+  var redundant [][2]*Stage
+  for _, from := range stages {
+    for _, to := range g.down[from] {
+      visited := make(map[*Stage]bool)
+      for _, d := range g.down[from] {
+        if d != to && g.reaches(d, to, visited) {
+          redundant = append(redundant, [2]*Stage{from, to})
+          break
+        }
+      }
+    }
+  }
+  return redundant
 }
 
-// areUpstreamFinished is recursive. If upstream stage is skipped then we need to look at it's dependencies too; all
-// must be finished or skipped. If there are no upstream then always returns true
-func (g *Graph) areUpstreamFinished(stage *Stage) bool {
+// reaches is true if the target stage can be reached by following normal links downstream from stage s.
+func (g *Graph) reaches(s *Stage, target *Stage, visited map[*Stage]bool) bool {
+  if s == target {
+    return true
+  }
+  if visited[s] {
+    return false
+  }
+  visited[s] = true
+  for _, d := range g.down[s] {
+    if g.reaches(d, target, visited) {
+      return true
+    }
+  }
+  return false
+}
+
+// effectiveUpstream returns the upstream stages that a stage's trigger rule should be evaluated against. Skipped stages
+// are treated as if they don't exist, so their own upstream stages are used in their place, recursively. If
+// lookThroughSkipped is false then skipped stages are returned as they are.
+func (g *Graph) effectiveUpstream(stage *Stage, lookThroughSkipped bool) []*Stage {
+  var upstream []*Stage
   for _, upstreamStage := range g.up[stage] {
-    // all upstream stages must be finished or skipped
-    switch upstreamStage.State {
-    case finished, excluded:
-      continue
-    case skipped:
-      g.areUpstreamFinished(upstreamStage) // recurse
-    default:
-      return false
+    if upstreamStage.State == skipped && lookThroughSkipped {
+      for _, u := range g.effectiveUpstream(upstreamStage, lookThroughSkipped) { // recurse
+        if !stageListContains(upstream, u) {
+          upstream = append(upstream, u)
+        }
+      }
+    } else if !stageListContains(upstream, upstreamStage) {
+      upstream = append(upstream, upstreamStage)
     }
   }
-  return true
+  return upstream
 }
 
 func (g *Graph) Print() {
   fmt.Println("looking downstream:")
-  for stage, _ := range g.down {
+  for stage := range g.down {
     for _, downstreamStage := range g.down[stage] {
       fmt.Println("  " + stage.Name + " > " + downstreamStage.Name)
     }
   }
   fmt.Println("looking upstream:")
-  for stage, _ := range g.up {
+  for stage := range g.up {
     for _, upstreamStage := range g.up[stage] {
       fmt.Println("  " + upstreamStage.Name + " > " + stage.Name)
     }
   }
+  fmt.Println("looking at handlers:")
+  for stage := range g.handlers {
+    for _, e := range g.handlers[stage] {
+      fmt.Println("  " + stage.Name + " > " + e.stage.Name + " (on " + e.on.String() + ")")
+    }
+  }
 }
 
 // NewGraph builds graph object.
@@ -105,7 +233,7 @@ func (g *Graph) Print() {
 func NewGraph(m *Mission) *Graph {
 
   // build graph object
-  graph := &Graph{make(map[*Stage][]*Stage), make(map[*Stage][]*Stage)}
+  graph := &Graph{make(map[*Stage][]*Stage), make(map[*Stage][]*Stage), make(map[*Stage][]edge), make(map[*Stage][]edge)}
 
   // are all stages referred to in upstream/downstream defined?
   for _, s := range m.Stages {
@@ -121,6 +249,16 @@ func NewGraph(m *Mission) *Graph {
         graph.AddLink(s, downstreamStage)
       }
     }
+    for _, h := range s.OnSuccess {
+      if handlerStage, err := m.GetStage(h); err == nil {
+        graph.AddTypedLink(s, handlerStage, finished)
+      }
+    }
+    for _, h := range s.OnFailure {
+      if handlerStage, err := m.GetStage(h); err == nil {
+        graph.AddTypedLink(s, handlerStage, failed)
+      }
+    }
   }
 
   return graph
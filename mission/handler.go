@@ -0,0 +1,101 @@
+package mission
+
+import "fmt"
+
+// fires is true if the outcome of the stage on the other end of the link means the handler stage is eligible to run.
+func (e edge) fires() bool {
+	switch e.on {
+	case finished:
+		return e.stage.State == finished
+	case failed:
+		return e.stage.hasFailed()
+	}
+	return false
+}
+
+// impossible is true if the stage on the other end of the link can no longer end in the state that fires the link.
+func (e edge) impossible() bool {
+	switch e.stage.State {
+	case excluded, skipped:
+		return true
+	case finished:
+		return e.on == failed
+	}
+	return e.on == finished && e.stage.failedPermanently()
+}
+
+// evaluateHandlers determines whether a stage linked by on_success or on_failure links is eligible to run. At least one
+// of its links must fire, i.e. the stage it handles must have finished (on_success) or failed (on_failure). Stages that
+// aren't linked by any typed links are always satisfied.
+func (m *Mission) evaluateHandlers(s *Stage) (satisfied bool, possible bool, blocking *edge) {
+	links := m.graph.handles[s]
+	if len(links) == 0 {
+		return true, true, nil
+	}
+	for i, e := range links {
+		if e.fires() {
+			return true, true, nil
+		}
+		if !e.impossible() {
+			possible = true
+			if blocking == nil {
+				blocking = &links[i]
+			}
+		}
+	}
+	if blocking == nil {
+		blocking = &links[0]
+	}
+	return false, possible, blocking
+}
+
+// evaluateFinalizer determines whether a finalizer stage is eligible to run. Finalizers run once every other stage in
+// the mission is done, regardless of whether they finished, failed, or were skipped or excluded.
+func (m *Mission) evaluateFinalizer(s *Stage) (satisfied bool, blocking *Stage) {
+	for _, other := range m.Stages {
+		if other.Finalizer {
+			continue
+		}
+		if !other.isDone() {
+			return false, other
+		}
+	}
+	return true, nil
+}
+
+// dependencyStatus determines whether a stage is eligible to run according to its trigger rule, its on_success and
+// on_failure links, and whether it is a finalizer. If the stage isn't eligible, the reason is given so that a helpful
+// error can be returned, and possible is false if it can never become eligible.
+func (m *Mission) dependencyStatus(s *Stage) (satisfied bool, possible bool, reason string) {
+	if s.Finalizer {
+		if satisfied, blocking := m.evaluateFinalizer(s); !satisfied {
+			return false, true, fmt.Sprintf("it is a finalizer and stage '%v' is %v", blocking.Name, blocking.State)
+		}
+		return true, true, ""
+	}
+
+	if satisfied, possible, blocking := m.evaluateHandlers(s); !satisfied {
+		action := "finished"
+		if blocking.on == failed {
+			action = "failed"
+		}
+		return false, possible, fmt.Sprintf("it only runs once '%v' has %v, and it is %v", blocking.stage.Name, action, blocking.stage.State)
+	}
+
+	satisfied, possible, blocking := m.evaluateTriggerRule(s)
+	if satisfied {
+		return true, true, ""
+	}
+	// find the reason in order to provide a helpful error message
+	switch {
+	case blocking == nil:
+		reason = fmt.Sprintf("its trigger rule '%v' is not satisfied", s.TriggerRule)
+	case !stageListContains(m.graph.up[s], blocking):
+		reason = fmt.Sprintf("a skipped upstream stage has unfinished upstream dependency '%v'", blocking.Name)
+	case s.TriggerRule == oneFailed:
+		reason = fmt.Sprintf("none of its upstream dependencies have failed, e.g. '%v' is %v", blocking.Name, blocking.State)
+	default:
+		reason = fmt.Sprintf("it has unfinished upstream dependency '%v'", blocking.Name)
+	}
+	return false, possible, reason
+}
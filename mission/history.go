@@ -0,0 +1,52 @@
+package mission
+
+import (
+	"fmt"
+	"time"
+)
+
+// Transition is a change in the state of a stage. Every stage keeps a list of its transitions, which is only ever
+// appended to, so that earlier attempts can still be seen after a stage has been retried or reset.
+type Transition struct {
+	From    state     `json:"f" name:"from"`
+	To      state     `json:"s" name:"to"`
+	Time    time.Time `json:"t" name:"time"`
+	Attempt int       `json:"c,omitempty" name:"attempt"`
+	Caller  string    `json:"a,omitempty" name:"caller"`  // name of the key, or IP address, that made the change
+	Message string    `json:"m,omitempty" name:"message"` // why the state was changed, if known
+}
+
+func (t Transition) String() string {
+	s := fmt.Sprintf("%v %v -> %v", t.Time.Format(time.RFC3339), t.From, t.To)
+	if t.Attempt > 0 {
+		s += fmt.Sprintf(" (attempt %v)", t.Attempt)
+	}
+	if t.Caller != "" {
+		s += fmt.Sprintf(" by %v", t.Caller)
+	}
+	if t.Message != "" {
+		s += ": " + t.Message
+	}
+	return s
+}
+
+// Annotate sets who is making the next change to the mission, and optionally why. These are recorded in the history of
+// every stage whose state is changed, until the mission is annotated again.
+func (m *Mission) Annotate(caller string, message string) {
+	m.caller = caller
+	m.message = message
+}
+
+// setState changes the state of a stage and records the transition in its history. Changes made as a consequence of
+// another change, such as stages being skipped automatically, are given their own message.
+func (m *Mission) setState(s *Stage, to state, message string) {
+	s.History = append(s.History, Transition{
+		From:    s.State,
+		To:      to,
+		Time:    time.Now(),
+		Attempt: s.Attempts,
+		Caller:  m.caller,
+		Message: message,
+	})
+	s.State = to
+}
@@ -0,0 +1,92 @@
+package mission
+
+import "sort"
+
+// RelatedStage is a stage found by following the links upstream or downstream of another stage.
+type RelatedStage struct {
+	Name    string   `json:"name"`
+	Depth   int      `json:"depth"`             // number of links between this stage and the stage that was queried
+	State   string   `json:"state,omitempty"`   // only given for missions
+	Outputs []string `json:"outputs,omitempty"` // outputs of this stage that are set or are used by other stages
+}
+
+// Upstream returns every stage that the stage given depends on, directly or indirectly, ordered by depth. Stages it
+// handles the outcome of through on_success/on_failure links are included. If depth is more than 0 then only stages
+// within that many links are returned. If showState is true then the state of each stage is given.
+func (m *Mission) Upstream(stageName string, depth int, showState bool) ([]RelatedStage, error) {
+	return m.related(stageName, depth, showState, func(s *Stage) []*Stage {
+		previous := append([]*Stage{}, m.graph.up[s]...)
+		for _, e := range m.graph.handles[s] {
+			previous = append(previous, e.stage)
+		}
+		return previous
+	})
+}
+
+// Downstream returns every stage that depends on the stage given, directly or indirectly, ordered by depth. These are
+// the stages that are affected if the stage fails or produces bad outputs. Handler stages are included. If depth is
+// more than 0 then only stages within that many links are returned. If showState is true then the state of each stage
+// is given.
+func (m *Mission) Downstream(stageName string, depth int, showState bool) ([]RelatedStage, error) {
+	return m.related(stageName, depth, showState, m.graph.next)
+}
+
+// related does a breadth first search from a stage, following the links given by the links function.
+func (m *Mission) related(stageName string, depth int, showState bool, links func(s *Stage) []*Stage) ([]RelatedStage, error) {
+	start, err := m.GetStage(stageName)
+	if err != nil {
+		return nil, err
+	}
+	outputs := m.referencedOutputs()
+
+	related := []RelatedStage{}
+	seen := map[*Stage]bool{start: true}
+	current := []*Stage{start}
+	for d := 1; len(current) > 0 && (depth <= 0 || d <= depth); d++ {
+		var next []*Stage
+		for _, s := range current {
+			for _, l := range links(s) {
+				if seen[l] {
+					continue
+				}
+				seen[l] = true
+				next = append(next, l)
+
+				r := RelatedStage{Name: l.Name, Depth: d, Outputs: append([]string{}, outputs[l.Name]...)}
+				for k := range l.Outputs {
+					if !contains(r.Outputs, k) {
+						r.Outputs = append(r.Outputs, k)
+					}
+				}
+				sort.Strings(r.Outputs)
+				if showState {
+					r.State = l.State.String()
+				}
+				related = append(related, r)
+			}
+		}
+		current = next
+	}
+	return related, nil
+}
+
+// referencedOutputs returns the names of the outputs of each stage that are used by templates in params, e.g. 'rows'
+// for 'extract' in '{{ stages.extract.outputs.rows }}'.
+func (m *Mission) referencedOutputs() map[string][]string {
+	outputs := make(map[string][]string)
+	paramSets := []map[string]interface{}{m.Params}
+	for _, s := range m.Stages {
+		paramSets = append(paramSets, s.Params)
+	}
+	for _, params := range paramSets {
+		for _, v := range params {
+			for _, expression := range findTemplates(v) {
+				path := templatePath(expression)
+				if len(path) > 3 && path[0] == "stages" && path[2] == "outputs" && !contains(outputs[path[1]], path[3]) {
+					outputs[path[1]] = append(outputs[path[1]], path[3])
+				}
+			}
+		}
+	}
+	return outputs
+}
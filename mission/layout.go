@@ -0,0 +1,247 @@
+package mission
+
+import "sort"
+
+// Position is the location of a stage when the mission's graph is drawn, in pixels from the top left.
+type Position struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// distances between the positions of stages in a layout, in pixels
+const (
+	layoutColumnSpacing = 220
+	layoutRowSpacing    = 60
+)
+
+// layoutSweeps is the maximum number of times the stages are reordered to reduce the number of crossed links.
+const layoutSweeps = 12
+
+// layers arranges the stages into columns, where every stage is in a later column than all the stages it depends on.
+// Finalizers aren't linked to any stages so are always in the last column.
+func (m *Mission) layers() [][]*Stage {
+	depth := make(map[*Stage]int)
+	visiting := make(map[*Stage]bool)
+	var visit func(s *Stage) int
+	visit = func(s *Stage) int {
+		if d, ok := depth[s]; ok {
+			return d
+		}
+		if visiting[s] {
+			return 0 // the graph is cyclic, which is only possible if the mission hasn't been validated
+		}
+		visiting[s] = true
+		d := 0
+		previous := append([]*Stage{}, m.graph.up[s]...)
+		for _, e := range m.graph.handles[s] {
+			previous = append(previous, e.stage)
+		}
+		for _, p := range previous {
+			if pd := visit(p) + 1; pd > d {
+				d = pd
+			}
+		}
+		depth[s] = d
+		return d
+	}
+
+	last := 0
+	for _, s := range m.Stages {
+		if !s.Finalizer && visit(s) > last {
+			last = depth[s]
+		}
+	}
+	var layers [][]*Stage
+	for _, s := range m.Stages {
+		d := depth[s]
+		if s.Finalizer {
+			d = last + 1
+		}
+		for len(layers) <= d {
+			layers = append(layers, nil)
+		}
+		layers[d] = append(layers[d], s)
+	}
+	return layers
+}
+
+// Layout arranges the mission's graph in columns from left to right, so that every stage is to the right of the stages
+// it depends on, and orders the stages within each column to minimise the number of links that cross each other. This
+// is a Sugiyama-style layered layout. Links that span more than one column are routed through placeholder positions in
+// each column they cross, so they take up space like stages do. Stages given in pinned keep their position. Returns
+// the position of every stage.
+func (m *Mission) Layout(pinned map[string]Position) map[string]Position {
+	// every node is either a stage or a placeholder for a link that passes through its column
+	type node struct {
+		stage *Stage
+		rank  int
+		up    []int
+		down  []int
+	}
+	var nodes []*node
+	index := make(map[*Stage]int, len(m.Stages))
+	layers := m.layers()
+	order := make([][]int, len(layers))
+	for rank, layer := range layers {
+		for _, s := range layer {
+			index[s] = len(nodes)
+			order[rank] = append(order[rank], len(nodes))
+			nodes = append(nodes, &node{stage: s, rank: rank})
+		}
+	}
+	for _, l := range m.links() {
+		from, to := index[l.from], index[l.to]
+		if nodes[to].rank <= nodes[from].rank {
+			continue // only possible if the graph is cyclic
+		}
+		for rank := nodes[from].rank + 1; rank < nodes[to].rank; rank++ {
+			placeholder := len(nodes)
+			nodes = append(nodes, &node{rank: rank})
+			order[rank] = append(order[rank], placeholder)
+			nodes[from].down = append(nodes[from].down, placeholder)
+			nodes[placeholder].up = append(nodes[placeholder].up, from)
+			from = placeholder
+		}
+		nodes[from].down = append(nodes[from].down, to)
+		nodes[to].up = append(nodes[to].up, from)
+	}
+
+	position := make([]int, len(nodes)) // position of each node within its column
+	setPositions := func() {
+		for _, layer := range order {
+			for i, n := range layer {
+				position[n] = i
+			}
+		}
+	}
+	crossings := func() int {
+		count := 0
+		for _, layer := range order {
+			var links [][2]int
+			for _, n := range layer {
+				for _, d := range nodes[n].down {
+					links = append(links, [2]int{position[n], position[d]})
+				}
+			}
+			for i := range links {
+				for j := i + 1; j < len(links); j++ {
+					if (links[i][0]-links[j][0])*(links[i][1]-links[j][1]) < 0 {
+						count++
+					}
+				}
+			}
+		}
+		return count
+	}
+	// sortByBarycenter orders a column by the average position of each node's neighbours in the adjacent column.
+	// Nodes without neighbours keep their position.
+	sortByBarycenter := func(layer []int, neighbours func(n int) []int) {
+		barycenter := make(map[int]float64, len(layer))
+		for i, n := range layer {
+			barycenter[n] = float64(i)
+			if ns := neighbours(n); len(ns) > 0 {
+				total := 0
+				for _, neighbour := range ns {
+					total += position[neighbour]
+				}
+				barycenter[n] = float64(total) / float64(len(ns))
+			}
+		}
+		sort.SliceStable(layer, func(i, j int) bool { return barycenter[layer[i]] < barycenter[layer[j]] })
+		for i, n := range layer {
+			position[n] = i
+		}
+	}
+
+	setPositions()
+	best, bestOrder := crossings(), copyOrder(order)
+	for sweep := 0; sweep < layoutSweeps && best > 0; sweep++ {
+		if sweep%2 == 0 {
+			for rank := 1; rank < len(order); rank++ {
+				sortByBarycenter(order[rank], func(n int) []int { return nodes[n].up })
+			}
+		} else {
+			for rank := len(order) - 2; rank >= 0; rank-- {
+				sortByBarycenter(order[rank], func(n int) []int { return nodes[n].down })
+			}
+		}
+		if c := crossings(); c < best {
+			best, bestOrder = c, copyOrder(order)
+		}
+	}
+	order = bestOrder
+
+	// columns are centred vertically
+	tallest := 0
+	for _, layer := range order {
+		if len(layer) > tallest {
+			tallest = len(layer)
+		}
+	}
+	positions := make(map[string]Position, len(m.Stages))
+	for rank, layer := range order {
+		offset := (tallest - len(layer)) * layoutRowSpacing / 2
+		for i, n := range layer {
+			if s := nodes[n].stage; s != nil {
+				positions[s.Name] = Position{rank * layoutColumnSpacing, offset + i*layoutRowSpacing}
+			}
+		}
+	}
+	for name, p := range pinned {
+		if _, ok := positions[name]; ok {
+			positions[name] = p
+		}
+	}
+	return positions
+}
+
+func copyOrder(order [][]int) [][]int {
+	c := make([][]int, len(order))
+	for i, layer := range order {
+		c[i] = append([]int{}, layer...)
+	}
+	return c
+}
+
+// SetPositions sets the position of each stage to the position given, so that every client draws the mission's graph
+// in the same way. Stages that aren't given keep their position.
+func (m *Mission) SetPositions(positions map[string]Position) {
+	for _, s := range m.Stages {
+		if p, ok := positions[s.Name]; ok {
+			s.X, s.Y = p.X, p.Y
+		}
+	}
+}
+
+// pinnedPositions returns the position of every stage that isn't where Layout would put it, i.e. the stages that were
+// given a position by the plan's author. Pinning a stage doesn't move any other stage, so the rest match the layout.
+func (m *Mission) pinnedPositions() map[string]Position {
+	layout := m.Layout(nil)
+	pinned := make(map[string]Position)
+	for _, s := range m.Stages {
+		if p := (Position{s.X, s.Y}); p != layout[s.Name] {
+			pinned[s.Name] = p
+		}
+	}
+	return pinned
+}
+
+// hasLayout is true if the stages have been given positions, which missions created before layouts were computed, and
+// missions that haven't been created from a plan, don't have.
+func (m *Mission) hasLayout() bool {
+	for _, s := range m.Stages {
+		if s.X != 0 || s.Y != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Positions returns the position of every stage.
+func (m *Mission) Positions() map[string]Position {
+	positions := make(map[string]Position, len(m.Stages))
+	for _, s := range m.Stages {
+		positions[s.Name] = Position{s.X, s.Y}
+	}
+	return positions
+}
@@ -0,0 +1,120 @@
+package mission
+
+import (
+	"fmt"
+	"time"
+)
+
+// Lease is given to a stage when it is started by a worker that pulls work from the API, instead of being triggered.
+// The worker must renew the lease before it expires, otherwise the stage is returned to ready so that another worker
+// can run it.
+type Lease struct {
+	Worker string    `json:"w" name:"worker"`
+	Expiry time.Time `json:"e" name:"expiry"`
+}
+
+// LeaseStage starts a stage on behalf of a worker, and leases it to the worker until the expiry given. The stage must
+// be able to start in the same way as with StartStage. Sub-plan stages can't be leased because they are run by the API
+// as child missions.
+func (m *Mission) LeaseStage(stageName string, worker string, expiry time.Time) (Response, error) {
+	s, err := m.GetStage(stageName)
+	if err != nil {
+		return Response{}, err
+	}
+	if s.SubPlan() != "" {
+		return Response{}, &StageChangeError{fmt.Sprintf("cannot lease stage '%v' because it runs plan '%v' as a child mission", stageName, s.SubPlan())}
+	}
+	m.Annotate(worker, fmt.Sprintf("leased to worker '%v'", worker))
+	res, err := m.StartStage(stageName, false)
+	if err != nil {
+		return res, err
+	}
+	s.Lease = &Lease{Worker: worker, Expiry: expiry}
+	return res, nil
+}
+
+// RenewLease extends the lease of a stage that is in progress. Only the worker that holds the lease can renew it.
+func (m *Mission) RenewLease(stageName string, worker string, expiry time.Time) error {
+	s, err := m.GetStage(stageName)
+	if err != nil {
+		return err
+	}
+	if s.State != started || s.Lease == nil {
+		return &StageChangeError{fmt.Sprintf("cannot renew the lease of stage '%v' because it is %v and isn't leased - the lease may have expired", stageName, s.State)}
+	}
+	if s.Lease.Worker != worker {
+		return &StageChangeError{fmt.Sprintf("cannot renew the lease of stage '%v' because it is leased to worker '%v'", stageName, s.Lease.Worker)}
+	}
+	s.Lease.Expiry = expiry
+	return nil
+}
+
+// CheckLease returns an error if a worker is given and the stage isn't leased to it, e.g. because its lease expired and
+// the stage was leased to another worker. This stops workers that have lost their lease from finishing or failing a
+// stage that is being run by someone else. Callers that aren't workers, e.g. an operator fixing a stuck stage, don't
+// give a worker and can always update the stage.
+func (m *Mission) CheckLease(stageName string, worker string) error {
+	s, err := m.GetStage(stageName)
+	if err != nil {
+		return err
+	}
+	if worker == "" {
+		return nil
+	}
+	if s.Lease == nil {
+		return &StageChangeError{fmt.Sprintf("cannot update stage '%v' because it isn't leased to worker '%v' - the lease may have expired", stageName, worker)}
+	}
+	if s.Lease.Worker != worker {
+		return &StageChangeError{fmt.Sprintf("cannot update stage '%v' because it is leased to worker '%v'", stageName, s.Lease.Worker)}
+	}
+	return nil
+}
+
+// leaseExpired is true if the stage is in progress and its lease has expired.
+func (s *Stage) leaseExpired(now time.Time) bool {
+	return s.State == started && s.Lease != nil && now.After(s.Lease.Expiry)
+}
+
+// HasExpiredLeases is true if ReleaseExpiredLeases would change the mission. This allows the API to check a mission
+// without needing a transaction.
+func (m *Mission) HasExpiredLeases(now time.Time) bool {
+	if m.isComplete || !m.End.IsZero() {
+		return false
+	}
+	for _, s := range m.Stages {
+		if s.leaseExpired(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReleaseExpiredLeases returns every stage whose lease has expired to ready, so that they can be leased again, and
+// returns their names. The attempt made by the worker that held the lease still counts towards the stage's retry
+// policy, so if it was the stage's last attempt then the stage is failed instead, and is returned in exhausted.
+func (m *Mission) ReleaseExpiredLeases(now time.Time) (released []string, exhausted []string) {
+	if m.isComplete || !m.End.IsZero() {
+		return released, exhausted
+	}
+	for _, s := range m.Stages {
+		if !s.leaseExpired(now) {
+			continue
+		}
+		message := fmt.Sprintf("lease held by worker '%v' expired", s.Lease.Worker)
+		s.Lease = nil
+		if s.Retry != nil && s.Attempts >= s.Retry.MaxAttempts {
+			s.Reason = message
+			m.setState(s, failed, message)
+			s.End = now
+			exhausted = append(exhausted, s.Name)
+			continue
+		}
+		m.setState(s, ready, message)
+		s.Start = time.Time{}
+		released = append(released, s.Name)
+	}
+	if len(exhausted) > 0 {
+		m.propagate()
+	}
+	return released, exhausted
+}
@@ -0,0 +1,102 @@
+package mission
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Lint returns every problem found by Problems, plus warnings about parts of the mission that are valid but are
+// probably a mistake. Warnings never make the mission invalid:
+// - links that are implied by a longer path between the same stages, which a transitive reduction would remove
+// - links that are declared on both stages, i.e. in the upstream of one and the downstream of the other
+// - services that are defined but not used by any stage
+// - stages without a service, which can only be run manually
+// - mission params that aren't used by any template and are overridden by every stage, so never reach a service
+func (m *Mission) Lint() []Problem {
+	ps := problems(m.Problems())
+
+	cyclic := false
+	for _, p := range ps {
+		cyclic = cyclic || p.Code == "cycle"
+	}
+	if !cyclic {
+		for _, link := range m.graph.RedundantLinks(m.Stages) {
+			from, to := link[0], link[1]
+			ps.warn("redundant_link", stagePath(m.stageIndex(from), "downstream"), from.Name, "stage '%v' doesn't need to be linked to '%v' because '%v' is already downstream of '%v' through other stages", from.Name, to.Name, to.Name, from.Name)
+		}
+	}
+
+	for i, s := range m.Stages {
+		for j, u := range s.Upstream {
+			upstream, err := m.GetStage(u)
+			if err == nil && contains(upstream.Downstream, s.Name) {
+				ps.warn("duplicate_link", stagePath(i, fmt.Sprintf("upstream[%v]", j)), s.Name, "the link from '%v' to '%v' is declared on both stages - it only needs to be declared on one", u, s.Name)
+			}
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, s := range m.Stages {
+		used[s.Service] = true
+	}
+	for i, service := range m.Services {
+		if !used[service] {
+			ps.warn("unused_service", fmt.Sprintf("services[%v]", i), "", "service '%v' is defined but not used by any stage", service)
+		}
+	}
+
+	for i, s := range m.Stages {
+		if s.Service == "" && s.MappedFrom == "" {
+			ps.warn("no_service", stagePath(i, "service"), s.Name, "stage '%v' has no service, so it can only be run manually", s.Name)
+		}
+	}
+
+	// mission params are given to every stage, so they're only unused if every stage overrides them
+	referenced := m.referencedParams()
+	var names []string
+	for name := range m.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !referenced[name] && m.overriddenByEveryStage(name) {
+			ps.warn("unused_param", "params."+name, "", "param '%v' is not used by any template, and every stage overrides it with its own value", name)
+		}
+	}
+
+	return ps
+}
+
+// overriddenByEveryStage is true if every stage defines a param with the name given, so that the stages never see the
+// mission param of the same name.
+func (m *Mission) overriddenByEveryStage(name string) bool {
+	for _, s := range m.Stages {
+		if _, ok := s.Params[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// referencedParams returns the names of all params referred to by templates, e.g. 'date' in '{{ params.date }}'.
+func (m *Mission) referencedParams() map[string]bool {
+	referenced := make(map[string]bool)
+	paramSets := []map[string]interface{}{m.Params}
+	for _, s := range m.Stages {
+		paramSets = append(paramSets, s.Params)
+		if s.isMapStage() {
+			referenced[s.MapOver] = true
+		}
+	}
+	for _, params := range paramSets {
+		for _, v := range params {
+			for _, expression := range findTemplates(v) {
+				path := templatePath(expression)
+				if len(path) > 1 && path[0] == "params" {
+					referenced[path[1]] = true
+				}
+			}
+		}
+	}
+	return referenced
+}
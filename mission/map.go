@@ -0,0 +1,229 @@
+package mission
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A map stage declares 'map_over', the name of a param or upstream output containing a list. Once the map stage is
+// eligible to run, it is expanded into one instance per item in the list, e.g. 'load[0]' to 'load[n]', and each
+// instance is given its item as the value of the param. The map stage itself is never started by a service. Instead,
+// it acts as the reduce point: its state is set by its instances, and its downstream stages wait for all of them to
+// finish.
+
+// instanceName returns the name of the i-th instance of a map stage.
+func instanceName(stageName string, i int) string {
+	return fmt.Sprintf("%v[%v]", stageName, i)
+}
+
+// isMapStage is true if the stage is expanded into instances at runtime.
+func (s *Stage) isMapStage() bool {
+	return s.MapOver != ""
+}
+
+// instances returns all instances that a map stage has been expanded into.
+func (m *Mission) instances(s *Stage) []*Stage {
+	var instances []*Stage
+	for _, i := range m.Stages {
+		if i.MappedFrom == s.Name {
+			instances = append(instances, i)
+		}
+	}
+	return instances
+}
+
+// mapItems returns the list that a map stage should be expanded over. The list is taken from the stage's params if
+// defined there or in the mission's params, which may refer to the outputs of other stages, otherwise from the outputs
+// of its upstream stages. Lists that have been converted to JSON strings are also allowed.
+func (m *Mission) mapItems(s *Stage) ([]interface{}, error) {
+	params, err := m.renderParams(s)
+	if err != nil {
+		return nil, err
+	}
+	value, ok := params[s.MapOver]
+	for _, u := range m.graph.effectiveUpstream(s, true) {
+		if ok {
+			break
+		}
+		value, ok = u.Outputs[s.MapOver]
+	}
+	if !ok {
+		return nil, fmt.Errorf("param '%v' is not defined", s.MapOver)
+	}
+	switch v := value.(type) {
+	case []interface{}:
+		return v, nil
+	case string:
+		var items []interface{}
+		if err := json.Unmarshal([]byte(v), &items); err == nil {
+			return items, nil
+		}
+	}
+	return nil, fmt.Errorf("param '%v' is not a list", s.MapOver)
+}
+
+// ExpandMapStages expands every map stage that is eligible to run into its instances. This happens automatically
+// whenever a stage changes state, but should also be run when a mission is created so that map stages without
+// upstream stages are expanded using the mission's params. An error is returned if a map stage's list can't be found.
+func (m *Mission) ExpandMapStages() error {
+	for _, s := range m.Stages {
+		if !s.isMapStage() || s.State != ready {
+			continue
+		}
+		if satisfied, _, _ := m.dependencyStatus(s); !satisfied {
+			continue
+		}
+		if _, err := m.mapItems(s); err != nil {
+			return &PlanValidationError{Detail: fmt.Sprintf("stage '%v' can't be mapped: %v", s.Name, err)}
+		}
+	}
+	m.propagate()
+	return nil
+}
+
+// expandMapStages adds instances to the mission for every map stage that is eligible to run, and returns true if any
+// were expanded. If the list can't be found, the map stage fails and can't be retried. The graph is laid out again once
+// the instances have been added, so that they are drawn between the map stage and its upstream stages, and every stage
+// that was placed by the plan's author keeps its position.
+func (m *Mission) expandMapStages() (changed bool) {
+	var pinned map[string]Position
+	for _, s := range m.Stages {
+		if !s.isMapStage() || s.State != ready {
+			continue
+		}
+		if satisfied, _, _ := m.dependencyStatus(s); !satisfied {
+			continue
+		}
+		if !changed {
+			pinned = m.pinnedPositions() // found before any instances are added
+		}
+		changed = true
+		now := time.Now()
+		s.Start = now
+
+		items, err := m.mapItems(s)
+		if err != nil {
+			s.Reason = fmt.Sprintf("could not expand map stage: %v", err)
+			m.setState(s, failed, s.Reason)
+			s.End = now
+			s.Fatal = true
+			continue
+		}
+
+		for i, item := range items {
+			params := make(map[string]interface{}, len(s.Params)+1)
+			for k, v := range s.Params {
+				params[k] = v
+			}
+			params[s.MapOver] = item
+			instance := &Stage{
+				Name:        instanceName(s.Name, i),
+				Service:     s.Service,
+				Upstream:    append([]string{}, s.Upstream...),
+				Downstream:  []string{s.Name},
+				Params:      params,
+				Retry:       s.Retry,
+				Timeout:     s.Timeout,
+				TriggerRule: s.TriggerRule,
+				MappedFrom:  s.Name,
+			}
+			m.Stages = append(m.Stages, instance)
+		}
+
+		if len(items) == 0 {
+			m.setState(s, finished, "expanded into 0 instances")
+			s.End = now
+		} else {
+			m.setState(s, started, fmt.Sprintf("expanded into %v instances", len(items)))
+		}
+	}
+	if changed {
+		m.graph = NewGraph(m)
+		m.SetPositions(m.Layout(pinned))
+	}
+	return changed
+}
+
+// reduceMapStages sets the state of every expanded map stage based on the state of its instances, and returns true if
+// any were changed. Instances are retried and timed out individually, so the map stage itself never is. A map stage
+// is finished once all of its instances are finished, skipped or excluded, and has failed once all of its instances
+// are done and at least one has failed.
+func (m *Mission) reduceMapStages() (changed bool) {
+	for _, s := range m.Stages {
+		if !s.isMapStage() || (s.State != started && s.State != finished && s.State != failed) {
+			continue
+		}
+		instances := m.instances(s)
+		if len(instances) == 0 {
+			continue // the map stage couldn't be expanded
+		}
+
+		newState, failures, fatal := finished, 0, false
+		for _, i := range instances {
+			if !i.isDone() {
+				newState = started
+			}
+			if i.hasFailed() {
+				failures++
+				fatal = fatal || i.failedPermanently()
+			}
+		}
+		if newState == finished && failures > 0 {
+			newState = failed
+		}
+		if newState == s.State && (newState != failed || fatal == s.Fatal) {
+			continue
+		}
+
+		changed = true
+		if newState != s.State {
+			m.setState(s, newState, "set by its instances")
+		}
+		s.Fatal = fatal
+		s.Reason = ""
+		s.End = time.Time{}
+		switch newState {
+		case finished:
+			s.End = time.Now()
+		case failed:
+			s.End = time.Now()
+			s.Reason = fmt.Sprintf("%v of %v instances failed", failures, len(instances))
+		}
+	}
+	return changed
+}
+
+// propagate updates the stages whose state depends on the state of other stages. Map stages are expanded and reduced,
+// and stages that can no longer run are skipped. This repeats until nothing changes.
+func (m *Mission) propagate() {
+	for changed := true; changed; {
+		changed = m.expandMapStages()
+		changed = m.reduceMapStages() || changed
+		changed = m.skipUnsatisfiableStages() || changed
+	}
+}
+
+// validateMapStage checks that map stages and their instances are consistent.
+func (m *Mission) validateMapStage(s *Stage) error {
+	if s.MappedFrom != "" {
+		template, err := m.GetStage(s.MappedFrom)
+		if err != nil || !template.isMapStage() {
+			return fmt.Errorf("it is an instance of '%v', which is not a map stage", s.MappedFrom)
+		}
+		return nil
+	}
+	if strings.ContainsAny(s.Name, "[]") {
+		return fmt.Errorf("stage names can't contain '[' or ']' because they are used to name map stage instances")
+	}
+	if s.isMapStage() && s.Finalizer {
+		return fmt.Errorf("finalizers can't be map stages")
+	}
+	return nil
+}
+
+// mapStageChangeError is returned when a user tries to change the state of a map stage, which is set by its instances.
+func mapStageChangeError(action string, s *Stage) error {
+	return &StageChangeError{fmt.Sprintf("cannot %v stage '%v' because it is mapped over '%v' - its state is set by its instances", action, s.Name, s.MapOver)}
+}
@@ -8,21 +8,32 @@ import (
 
 // Response given when the user requests to change the state of a stage, e.g. start, finish, ignore, skip.
 type Response struct {
-	Success    bool     `json:"success"`
-	Next       []string `json:"next"`
-	IsComplete bool     `json:"complete"`
+	Success    bool                   `json:"success"`
+	Next       []string               `json:"next"`
+	IsComplete bool                   `json:"complete"`
+	Retry      *RetryStatus           `json:"retry,omitempty"`  // only given when a stage with a retry policy fails
+	Params     map[string]interface{} `json:"params,omitempty"` // only given when a stage is started, with templates rendered
 }
 
 type Mission struct {
-	Id         string                 `json:"i" name:"id"`
-	Name       string                 `json:"n" name:"name"` // the plan name, note: not needed in a mission
-	Services   []string               `json:"a" name:"services"`
-	Stages     []*Stage               `json:"s" name:"stages"`
-	Params     map[string]interface{} `json:"p" name:"params"`
-	Start      time.Time              `json:"t" name:"start"`
-	End        time.Time              `json:"e" name:"end"`
-	isComplete bool
-	graph      *Graph
+	Id           string                 `json:"i" name:"id"`
+	Name         string                 `json:"n" name:"name"` // the plan name, note: not needed in a mission
+	Services     []string               `json:"a" name:"services"`
+	Stages       []*Stage               `json:"s" name:"stages"`
+	Params       map[string]interface{} `json:"p" name:"params"`
+	Start        time.Time              `json:"t" name:"start"`
+	End          time.Time              `json:"e" name:"end"`
+	Timeout      string                 `json:"o,omitempty" name:"timeout"`      // time.Duration string, e.g. '6h'
+	Parent       string                 `json:"r,omitempty" name:"parent"`       // id of the mission that this is a child mission of
+	ParentStage  string                 `json:"q,omitempty" name:"parent_stage"` // name of the stage that this is a child mission of
+	Paused       bool                   `json:"u,omitempty" name:"paused"`       // true if no stages can be started until the mission is resumed
+	Cancellation *Cancellation          `json:"c,omitempty" name:"cancellation"` // only given if the mission was cancelled
+	PlanVersion  int                    `json:"v,omitempty" name:"plan_version"` // version of the saved plan that the mission was created from
+	Triggers     map[string]Trigger     `json:"g,omitempty" name:"triggers"`     // trigger of each service, by service name
+	isComplete   bool
+	graph        *Graph
+	caller       string // who is making the current change, see Annotate
+	message      string // why the current change is being made, see Annotate
 }
 
 // NewFromJSON creates missions objects from their database representation in JSON.
@@ -35,6 +46,7 @@ func NewFromJSON(jsonString []byte) (Mission, error) {
 	}
 
 	m.graph = NewGraph(&m)
+	m.isComplete = !m.End.IsZero() // missions are given an end time once they are complete
 
 	return m, err
 }
@@ -59,69 +71,6 @@ func (m *Mission) Bytes() []byte {
 	return output
 }
 
-// Validate tests mission is valid using the following logic:
-// - more than 0 stages
-// - no duplicate stage names
-// - all referenced stages exist
-// - graph is not cyclic
-// - graph is contiguous (no orphaned stages)
-func (m *Mission) Validate() error {
-
-	// are there more than 0 stages?
-	if len(m.Stages) == 0 {
-		return &PlanValidationError{"plans must have more than 0 stages"}
-	}
-
-	// are there any duplicate stage names?
-	var stageNames []string
-	for _, s := range m.Stages {
-		if contains(stageNames, s.Name) {
-			err := &PlanValidationError{fmt.Sprintf("stage name '%v' is not unique", s.Name)}
-			return err
-		} else {
-			stageNames = append(stageNames, s.Name)
-		}
-	}
-
-	// are all stages referred to in upstream/downstream defined?
-	for _, s := range m.Stages {
-		for _, u := range s.Upstream {
-			if !contains(stageNames, u) {
-				return &PlanValidationError{fmt.Sprintf("stage '%v' has upstream dependency '%v' which is not defined", s.Name, u)}
-			}
-		}
-		for _, d := range s.Downstream {
-			if !contains(stageNames, d) {
-				return &PlanValidationError{fmt.Sprintf("stage '%v' has downstream dependency '%v' which is not defined", s.Name, d)}
-			}
-		}
-	}
-
-	// is graph cyclic?
-	// follow every path in the graph starting from each stage. If a stage ends up visiting itself then it's cyclic
-	visited := make(map[*Stage]bool)
-	recursion := make(map[*Stage]bool)
-	for _, s := range m.Stages {
-		visited[s] = false
-		recursion[s] = false
-	}
-	for _, s := range m.Stages {
-		if !visited[s] {
-			if m.graph.CheckForCycle(s, visited, recursion) {
-				return &PlanValidationError{fmt.Sprintf("stage '%v' is dependent on itself (infinite loop)", s.Name)}
-			}
-		}
-	}
-
-	// is graph contiguous?
-	// follow every path forwards and backwards from a single node and check that every node was visited at least once
-	if unreachableStage := m.graph.CheckForIncontiguity(m.Stages); unreachableStage != nil {
-		return &PlanValidationError{fmt.Sprintf("invalid plan: not contiguous - '%v' cannot be reached from '%v'", unreachableStage.Name, m.Stages[0].Name)}
-	}
-
-	return nil
-}
-
 // Print prints all the information about the mission.
 func (m *Mission) Print() {
 	fmt.Print("Mission\nid:", m.Id, m.isComplete)
@@ -144,11 +93,35 @@ func (m *Mission) Report() string {
 	}
 	reportText += "\n"
 	for _, s := range m.Stages {
-		reportText += fmt.Sprintln(stateIcons[s.State], s.Name, s.PrintDuration())
+		if s.MappedFrom != "" {
+			continue // instances are listed below their map stage
+		}
+		reportText += s.reportLine("")
+		for _, i := range m.instances(s) {
+			reportText += i.reportLine("  ")
+		}
 	}
 	return reportText
 }
 
+// reportLine gives the line of a mission report for a single stage.
+func (s *Stage) reportLine(indent string) string {
+	line := fmt.Sprint(indent, stateIcons[s.State], " ", s.Name, " ", s.PrintDuration())
+	if s.Retry != nil && s.Attempts > 0 {
+		line += fmt.Sprintf(" (attempt %v/%v)", s.Attempts, s.Retry.MaxAttempts)
+	}
+	if s.isMapStage() {
+		line += fmt.Sprintf(" (mapped over %v)", s.MapOver)
+	}
+	if s.State == failed && s.Reason != "" {
+		line += fmt.Sprintf("\n%v    %v", indent, s.Reason)
+	}
+	if s.Dispatch != nil && (s.Dispatch.Status == DispatchFailed || s.Dispatch.Status == DispatchSkipped) {
+		line += fmt.Sprintf("\n%v    %v", indent, s.Dispatch)
+	}
+	return line + "\n"
+}
+
 // exists so that one can find a stage within a stage list without needing a mission.
 func getStage(stageName string, stages []*Stage) (*Stage, error) {
 	for _, stage := range stages {
@@ -181,17 +154,22 @@ func (m *Mission) CheckComplete() {
 	m.End = time.Now()
 }
 
-// Next finds all stages that are eligible to run.
+// Next finds all stages that are eligible to run according to their trigger rules, on_success and on_failure links, and
+// finalizers. This includes failed stages that are due to be retried. No stages are eligible while the mission is
+// paused.
 func (m *Mission) Next() []string {
 
 	var nextStages []string
+	if m.Paused {
+		return nextStages // no stages can be started until the mission is resumed
+	}
 
 	for _, stage := range m.Stages {
-		if stage.State != ready {
+		if stage.State != ready && !stage.canRetry() {
 			continue
 		}
-		// all upstream stages must be finished or skipped
-		if !m.graph.areUpstreamFinished(stage) {
+		// the stage's trigger rule must be satisfied, by default all upstream stages must be finished or skipped
+		if satisfied, _, _ := m.dependencyStatus(stage); !satisfied {
 			continue
 		}
 		nextStages = append(nextStages, stage.Name)
@@ -207,73 +185,100 @@ func (m *Mission) Next() []string {
 // StartStage changes a stage's state to started using the following logic:
 // - does stage exist?
 // - is stage ready or failed? (all other states are not allowed)
-// - are all upstream dependencies finished or skipped?
+// - if failed, does the stage's retry policy allow another attempt yet?
+// - has the mission's timeout elapsed?
+// - is the mission paused?
+// - is the stage's trigger rule satisfied? (by default, are all upstream dependencies finished or skipped?)
+// - if the stage handles the outcome of another stage, has that stage finished or failed as required?
+// - if the stage is a finalizer, are all other stages done?
 func (m *Mission) StartStage(stageName string, ignoreDependencies bool) (Response, error) {
 	if m.isComplete {
-		return Response{false, nil, true}, &CompletedError{}
+		return Response{false, nil, true, nil, nil}, &CompletedError{}
+	}
+	if m.pastDeadline(time.Now()) {
+		err := &StageChangeError{fmt.Sprintf("cannot start stage '%v' because the mission has timed out - it did not complete within %v", stageName, m.Timeout)}
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	}
+	if m.Paused {
+		return Response{false, nil, m.isComplete, nil, nil}, pausedError(stageName)
 	}
 	s, err := m.GetStage(stageName)
 	if err != nil {
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	}
+	if s.isMapStage() {
+		return Response{false, nil, m.isComplete, nil, nil}, mapStageChangeError("start", s)
 	}
 
 	// has stage already started or is it already finished?
 	// has stage been excluded or skipped?
 	switch s.State {
-	case ready, failed:
-		// ok, failed stages can be started again (retry)
+	case ready:
+		// ok
+	case failed:
+		// ok, failed stages can be started again (retry) unless their retry policy prevents it
+		if s.retriesExhausted() {
+			err := &StageChangeError{fmt.Sprintf("cannot start stage '%v' because it has failed and can't be retried - %v of %v attempts were made", stageName, s.Attempts, s.Retry.MaxAttempts)}
+			return Response{false, nil, m.isComplete, s.retryStatus(), nil}, err
+		}
+		if after := s.retryAfter(); time.Now().Before(after) {
+			err := &StageChangeError{fmt.Sprintf("cannot start stage '%v' because it can't be retried until %v", stageName, after.Format(time.RFC3339))}
+			return Response{false, nil, m.isComplete, s.retryStatus(), nil}, err
+		}
 	case started:
 		err := &StageChangeError{fmt.Sprintf("cannot start stage '%v' because it has already started - stages can only be started again after they have been marked as failed", stageName)}
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	case finished:
 		err := &StageChangeError{fmt.Sprintf("cannot start stage '%v' because it has already finished", stageName)}
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	case excluded:
 		err := &StageChangeError{fmt.Sprintf("cannot start stage '%v' because it is being excluded", stageName)}
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	case skipped:
 		err := &StageChangeError{fmt.Sprintf("cannot start stage '%v' because it was skipped", stageName)}
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	}
 
+	previousState := s.State
 	if ignoreDependencies {
 		// mark all upstream stages as excluded recursively so that stage can be started
 		// pre-set this stage's state to excluded to prevent its downstream stages from being excluded by excludeUpstreamRecursively
 		s.State = excluded
-		err := m.excludeUpstreamRecursively(s)
+		err := m.excludeUpstreamRecursively(s, fmt.Sprintf("stage '%v' was started with dependencies ignored", s.Name))
 		if err != nil {
-			return Response{false, nil, m.isComplete}, err
+			return Response{false, nil, m.isComplete, nil, nil}, err
 		}
 	}
 
 	s.State = ready
 
-	// are all upstream dependencies finished, excluded, or skipped?
-	if !m.graph.areUpstreamFinished(s) {
-		var err error
-		// if not then find the unfinished stage in order to provide a helpful error message
-		for _, dependency := range m.graph.up[s] {
-			switch dependency.State {
-			case finished, excluded:
-				continue
-			case skipped:
-				if m.graph.areUpstreamFinished(s) {
-					continue
-				} else {
-					err = &StageChangeError{fmt.Sprintf("cannot start stage '%v' because skipped stage '%v' has unfinished upstream dependencies", stageName, dependency.Name)}
-				}
-			case started, failed, ready:
-				err = &StageChangeError{fmt.Sprintf("cannot start stage '%v' because it has unfinished upstream dependency '%v'", stageName, dependency.Name)}
-			}
-		}
-		return Response{false, nil, m.isComplete}, err
+	// is the stage eligible to run? (by default, are all of its upstream dependencies finished?)
+	if satisfied, _, reason := m.dependencyStatus(s); !satisfied && !ignoreDependencies {
+		s.State = previousState
+		err := &StageChangeError{fmt.Sprintf("cannot start stage '%v' because %v", stageName, reason)}
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	}
+
+	// render the stage's params, which may refer to the outputs of other stages
+	params, err := m.renderParams(s)
+	if err != nil {
+		s.State = previousState
+		err := &StageChangeError{fmt.Sprintf("cannot start stage '%v' because %v", stageName, err)}
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	}
 
 	// change the state
-	s.State = started
+	s.State = previousState
+	s.Attempts++
+	m.setState(s, started, m.message)
 	s.Start = time.Now()
+	s.End = time.Time{} // clear the end time of any previous attempt
+	s.Fatal = false
+	s.Reason = ""
+	s.Error = nil
+	s.Lease = nil
 
-	return Response{true, []string{}, m.isComplete}, nil
+	return Response{true, []string{}, m.isComplete, nil, params}, nil
 }
 
 // FinishStage changes a stage's state to finished using the following logic:
@@ -282,11 +287,14 @@ func (m *Mission) StartStage(stageName string, ignoreDependencies bool) (Respons
 // - are all upstream dependencies finished or skipped?
 func (m *Mission) FinishStage(stageName string, ignoreDependencies bool) (Response, error) {
 	if m.isComplete {
-		return Response{false, nil, true}, &CompletedError{}
+		return Response{false, nil, true, nil, nil}, &CompletedError{}
 	}
 	s, err := m.GetStage(stageName)
 	if err != nil {
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	}
+	if s.isMapStage() {
+		return Response{false, nil, m.isComplete, nil, nil}, mapStageChangeError("finish", s)
 	}
 
 	// has stage already finished or is it already finished?
@@ -296,34 +304,36 @@ func (m *Mission) FinishStage(stageName string, ignoreDependencies bool) (Respon
 		// ok
 	case excluded, skipped, ready:
 		err := &StageChangeError{fmt.Sprintf("cannot finish stage '%v' because it has not been started", stageName)}
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	case finished:
 		err := &StageChangeError{fmt.Sprintf("stage '%v' is already finished", stageName)}
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	case failed:
 		err := &StageChangeError{fmt.Sprintf("cannot finish stage '%v' because it is marked as failed", stageName)}
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	}
 
 	// change the state
-	s.State = finished
+	m.setState(s, finished, m.message)
 	s.End = time.Now()
+	s.Lease = nil
 
 	if ignoreDependencies {
 		// mark all downstream stages as excluded so that they don't run next
-		err := m.excludeDownstreamRecursively(s)
+		err := m.excludeDownstreamRecursively(s, fmt.Sprintf("stage '%v' was finished with dependencies ignored", s.Name))
 		if err != nil {
-			return Response{false, nil, m.isComplete}, err
+			return Response{false, nil, m.isComplete, nil, nil}, err
 		}
 	}
 
 	// find the next stages/check if mission is finished
+	m.propagate()
 	nextStages := m.Next()
 	if len(nextStages) == 0 {
 		m.CheckComplete()
 	}
 
-	return Response{true, nextStages, m.isComplete}, nil
+	return Response{true, nextStages, m.isComplete, nil, nil}, nil
 }
 
 // SkipStage changes a stage's state to skip using the following logic:
@@ -332,44 +342,53 @@ func (m *Mission) FinishStage(stageName string, ignoreDependencies bool) (Respon
 // - are all upstream dependencies finished or skipped?
 func (m *Mission) SkipStage(stageName string) (Response, error) {
 	if m.isComplete {
-		return Response{false, nil, true}, &CompletedError{}
+		return Response{false, nil, true, nil, nil}, &CompletedError{}
 	}
 	// Does stage exist
 	s, err := m.GetStage(stageName)
 	if err != nil {
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	}
+	if s.isMapStage() && s.State != ready {
+		return Response{false, nil, m.isComplete, nil, nil}, mapStageChangeError("skip", s)
 	}
 
 	// Check the state of the stage
 	switch s.State {
 	case ready, failed:
-		s.State = skipped
+		m.setState(s, skipped, m.message)
 	case skipped, excluded, finished:
 		// this is allowed, but state will not be changed - mission logic should not be affected
 	case started:
 		err := &StageChangeError{fmt.Sprintf("cannot skip stage '%v' because it has previously been %s", stageName, s.State)}
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	}
 
+	m.propagate()
 	nextStages := m.Next()
 	if len(nextStages) == 0 {
 		m.CheckComplete()
 	}
 
-	return Response{true, nextStages, m.isComplete}, nil
+	return Response{true, nextStages, m.isComplete, nil, nil}, nil
 }
 
 // FailStage changes a stage's state to failed using the following logic:
 // - does stage exist?
 // - state can't be ready, failed, excluded, skipped or failed, just started
-func (m *Mission) FailStage(stageName string) (Response, error) {
+// - if the stage has a retry policy, it will become eligible to run again after the backoff has elapsed, unless the
+// failure is fatal or all attempts have been used
+func (m *Mission) FailStage(stageName string, fatal bool) (Response, error) {
 	if m.isComplete {
-		return Response{false, nil, true}, &CompletedError{}
+		return Response{false, nil, true, nil, nil}, &CompletedError{}
 	}
 	// does stage exist
 	s, err := m.GetStage(stageName)
 	if err != nil {
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	}
+	if s.isMapStage() {
+		return Response{false, nil, m.isComplete, nil, nil}, mapStageChangeError("fail", s)
 	}
 
 	// check the state of the stage
@@ -378,11 +397,24 @@ func (m *Mission) FailStage(stageName string) (Response, error) {
 		// ok
 	case ready, excluded, skipped, finished, failed:
 		err := &StageChangeError{fmt.Sprintf("cannot fail stage '%v' because it is %s, not started", stageName, s.State)}
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	}
+
+	message := m.message
+	if message == "" {
+		message = s.Reason
 	}
+	m.setState(s, failed, message)
+	s.End = time.Now()
+	s.Fatal = fatal
+	s.Lease = nil
 
-	s.State = failed
-	return Response{true, []string{}, false}, nil
+	// the next stages may include this stage, if it can be retried immediately, its on_failure handlers, and any stages
+	// with trigger rules that allow them to run when an upstream stage fails
+	m.propagate()
+	nextStages := append([]string{}, m.Next()...)
+
+	return Response{true, nextStages, false, s.retryStatus(), nil}, nil
 }
 
 // ExcludeStage changes a stage's state to excluded using the following logic:
@@ -392,34 +424,38 @@ func (m *Mission) FailStage(stageName string) (Response, error) {
 // - all downstream dependencies must be excluded too
 func (m *Mission) ExcludeStage(stageName string) (Response, error) {
 	if m.isComplete {
-		return Response{false, nil, true}, &CompletedError{}
+		return Response{false, nil, true, nil, nil}, &CompletedError{}
 	}
 	// does stage exist
 	s, err := m.GetStage(stageName)
 
 	if err != nil {
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	}
+	if s.isMapStage() && s.State != ready {
+		return Response{false, nil, m.isComplete, nil, nil}, mapStageChangeError("exclude", s)
 	}
 
-	err = m.tryExcludingStage(s)
+	err = m.tryExcludingStage(s, m.message)
 	if err != nil {
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	}
 
 	// next exclude all downstream recursively
-	err = m.excludeDownstreamRecursively(s)
+	err = m.excludeDownstreamRecursively(s, fmt.Sprintf("upstream stage '%v' was excluded", s.Name))
 	if err != nil {
-		return Response{false, nil, m.isComplete}, err
+		return Response{false, nil, m.isComplete, nil, nil}, err
 	}
 
+	m.propagate()
 	m.CheckComplete()
-	return Response{true, []string{}, m.isComplete}, nil
+	return Response{true, []string{}, m.isComplete, nil, nil}, nil
 }
 
-func (m *Mission) tryExcludingStage(s *Stage) error {
+func (m *Mission) tryExcludingStage(s *Stage, message string) error {
 	switch s.State {
 	case ready, failed:
-		s.State = excluded
+		m.setState(s, excluded, message)
 		return nil
 	case finished, skipped, excluded:
 		// this is allowed, but state will not be changed - mission logic should not be affected
@@ -431,16 +467,16 @@ func (m *Mission) tryExcludingStage(s *Stage) error {
 	return nil
 }
 
-func (m *Mission) excludeDownstreamRecursively(s *Stage) error {
+func (m *Mission) excludeDownstreamRecursively(s *Stage, message string) error {
 	for _, downstreamStage := range m.graph.down[s] {
 		if downstreamStage.State == excluded {
 			// already seen, don't recurse
 		} else {
-			err := m.tryExcludingStage(downstreamStage)
+			err := m.tryExcludingStage(downstreamStage, message)
 			if err != nil {
 				return err
 			}
-			err = m.excludeDownstreamRecursively(downstreamStage)
+			err = m.excludeDownstreamRecursively(downstreamStage, message)
 			if err != nil {
 				return err
 			}
@@ -452,21 +488,21 @@ func (m *Mission) excludeDownstreamRecursively(s *Stage) error {
 // excludeUpstreamRecursively is only run when StartStage is run with ignoreDependencies set to true. It is used
 // to ensure that the stage can start without its dependencies being finished by excluding then all and also excludes
 // any stages that can no longer run due to their dependencies being excluded
-func (m *Mission) excludeUpstreamRecursively(s *Stage) error {
+func (m *Mission) excludeUpstreamRecursively(s *Stage, message string) error {
 	for _, upstreamStage := range m.graph.up[s] {
 		if upstreamStage.State == excluded {
 			// already seen, don't recurse
 		} else {
-			err := m.tryExcludingStage(upstreamStage)
+			err := m.tryExcludingStage(upstreamStage, message)
 			if err != nil {
 				return err
 			}
-			err = m.excludeUpstreamRecursively(upstreamStage)
+			err = m.excludeUpstreamRecursively(upstreamStage, message)
 			if err != nil {
 				return err
 			}
 			// now exclude any stages that can no longer run because their dependencies are excluded
-			err = m.excludeDownstreamRecursively(upstreamStage)
+			err = m.excludeDownstreamRecursively(upstreamStage, message)
 			if err != nil {
 				return err
 			}
@@ -5,6 +5,8 @@ The following capabilities are covered by these tests:
 - creating missions
 - not creating invalid/incorrectly formatted missions
 - not creating cyclic or incontiguous missions
+- reporting every validation problem at once, with its code, severity and path
+- linting missions for redundant links, unused services and params, and stages without services
 - changing stage state when allowed (e.g. started -> finished) for all types of state
 - failing to change stage state when not allowed (e.g. started -> started) for all types of state
 - completing missions with skipped stages
@@ -12,6 +14,23 @@ The following capabilities are covered by these tests:
 - completing missions with excluded stages
 - not completing when stages aren't finished, skipped, or excluded
 - not being able to run a mission out of order
+- retrying failed stages according to their retry policy
+- failing stages that exceed their timeout or the mission's timeout
+- trigger rules, including through chains of skipped stages
+- on_success/on_failure handler stages and finalizer stages
+- expanding map stages into instances and reducing them
+- sub-plan stages and detecting missions that can't progress because a stage has failed
+- passing stage outputs to downstream stage params
+- rendering and validating param templates
+- resetting stages and their downstream stages, including in completed missions
+- pausing, resuming, and cancelling missions
+- recording the history of every stage, including retries and automatic changes
+- storing the errors given when stages fail and showing them in the mission report
+- exporting mission graphs as DOT, Mermaid, and SVG
+- dry runs, including waves of stages, exclusions, and the critical path
+- finding every stage upstream or downstream of a stage
+- laying out mission graphs in columns with as few crossed links as possible, and pinning stage positions
+- leasing stages to workers, renewing leases, and returning stages to ready when their lease expires
 
 to run:
 
@@ -20,8 +39,12 @@ to run:
 package mission
 
 import (
+	"encoding/xml"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestMission_StartStage_ErrorAlreadyStarted(t *testing.T) {
@@ -149,7 +172,7 @@ func TestMission_FailStage_Error(t *testing.T) {
 	}
 
 	// test a stage that has not started can't fail
-	_, err = m.FailStage("stage-1")
+	_, err = m.FailStage("stage-1", false)
 	if err == nil {
 		t.Fatalf("Fail stage without starting should result in an error.")
 	}
@@ -157,7 +180,7 @@ func TestMission_FailStage_Error(t *testing.T) {
 	// test a stage that has finished can't fail
 	m.StartStage("stage-1", false)
 	m.FinishStage("stage-1", false)
-	_, err = m.FailStage("stage-1")
+	_, err = m.FailStage("stage-1", false)
 	if err == nil {
 		t.Fatalf("Fail stage thats finished should result in an error.")
 	}
@@ -174,7 +197,7 @@ func TestMission_FailStage(t *testing.T) {
 
 	//   test a stage that has started can fail
 	m.StartStage("stage-1", false)
-	_, err = m.FailStage("stage-1")
+	_, err = m.FailStage("stage-1", false)
 	if err != nil {
 		t.Fatalf("Started stage should fail")
 	}
@@ -264,4 +287,1149 @@ func TestMission_Validate_CheckContiguous(t *testing.T) {
 }
 
 // a mission with no links may cause unexpected behaviour as the graph will be completely empty
-// TODO: test that mission with no links fails validation with correct error message (incontiguous)
+func TestMission_Validate_NoLinks(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_no_links.json")
+	m, _ := NewFromJSON(data)
+	err := m.Validate()
+	if err == nil || !strings.Contains(err.Error(), "not contiguous") {
+		t.Fatalf("Mission with no links should fail validation because it is incontiguous, got: %v", err)
+	}
+}
+
+func TestMission_Validate_Problems(t *testing.T) {
+	m := New("problems", []*Stage{
+		{Name: "a", Service: "svc", Downstream: []string{"b"}},
+		{Name: "b", Service: "svc", Downstream: []string{"c"}},
+		{Name: "c", Service: "other", Downstream: []string{"a"}},
+		{Name: "b", Upstream: []string{"missing"}},
+		{Name: "d"},
+	})
+	m.Services = []string{"svc"}
+
+	err := m.Validate()
+	validationError, ok := err.(*PlanValidationError)
+	if !ok {
+		t.Fatalf("Mission should fail validation with a PlanValidationError, got: %v", err)
+	}
+
+	found := make(map[string]Problem)
+	for _, p := range validationError.Problems {
+		found[p.Code] = p
+	}
+	expected := map[string]string{
+		"duplicate_stage":    "stages[3].name",
+		"undefined_upstream": "stages[3].upstream[0]",
+		"undefined_service":  "stages[2].service",
+		"cycle":              "stages[0]",
+		"unreachable_stage":  "stages[4]",
+	}
+	for code, path := range expected {
+		p, ok := found[code]
+		if !ok {
+			t.Fatalf("Expected problem '%v' to be reported, got: %v", code, validationError.Problems)
+		}
+		if p.Path != path {
+			t.Errorf("Expected problem '%v' to be at '%v', got '%v'", code, path, p.Path)
+		}
+	}
+	for _, p := range validationError.Problems {
+		if p.Severity != SeverityError {
+			t.Errorf("Validation errors should only list errors, got: %v", p)
+		}
+	}
+	if !strings.Contains(found["cycle"].Message, "a > b > c > a") {
+		t.Errorf("Cycle problem should give the full cycle path, got: %v", found["cycle"].Message)
+	}
+	if !strings.Contains(err.Error(), "more problems") {
+		t.Errorf("Error message should say that there are more problems, got: %v", err)
+	}
+
+	// a stage using a service that isn't defined in the plan is enough to make the mission invalid
+	m = New("services", []*Stage{{Name: "a", Service: "other"}})
+	m.Services = []string{"svc"}
+	validationError, ok = m.Validate().(*PlanValidationError)
+	if !ok || len(validationError.Problems) != 1 || validationError.Problems[0].Code != "undefined_service" {
+		t.Fatalf("Expected one undefined_service error, got: %v", m.Validate())
+	}
+}
+
+func TestMission_Lint(t *testing.T) {
+	m := New("lint", []*Stage{
+		{Name: "a", Service: "svc", Downstream: []string{"b", "c"}, Params: map[string]interface{}{"env": "prod"}},
+		{Name: "b", Service: "svc", Downstream: []string{"c"}, Params: map[string]interface{}{"path": "{{ params.bucket }}/data", "env": "prod"}},
+		{Name: "c", Upstream: []string{"b"}, Params: map[string]interface{}{"env": "prod"}},
+	})
+	m.Services = []string{"svc", "unused"}
+	// 'table' is given to every stage's service, so it's used even though no template refers to it
+	m.Params = map[string]interface{}{"bucket": "b", "env": "dev", "table": "sales"}
+
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Mission should be valid, got: %v", err)
+	}
+	expected := map[string]string{
+		"redundant_link": "stages[0].downstream",
+		"duplicate_link": "stages[2].upstream[0]",
+		"unused_service": "services[1]",
+		"no_service":     "stages[2].service",
+		"unused_param":   "params.env",
+	}
+	problems := m.Lint()
+	if len(problems) != len(expected) {
+		t.Fatalf("Expected %v warnings, got: %v", len(expected), problems)
+	}
+	for _, p := range problems {
+		if p.Severity != SeverityWarning || expected[p.Code] != p.Path {
+			t.Fatalf("Unexpected problem: %v", p)
+		}
+	}
+}
+
+func TestMission_FailStage_Retry(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_retry.json")
+	m, _ := NewFromJSON(data)
+	err := m.Validate()
+	if err != nil {
+		t.Fatalf(`Test mission didn't pass validation.`)
+	}
+
+	// stage-1 has no backoff, so it should be eligible to run again immediately
+	m.StartStage("stage-1", false)
+	res, err := m.FailStage("stage-1", false)
+	if err != nil || res.Retry == nil || res.Retry.Exhausted {
+		t.Fatalf("Stage should fail and be retryable")
+	}
+	if len(res.Next) != 1 || res.Next[0] != "stage-1" {
+		t.Fatalf("Failed stage should be the next stage when it can be retried immediately")
+	}
+	if next := m.Next(); len(next) != 1 || next[0] != "stage-1" {
+		t.Fatalf("Failed stage should be returned by Next when it can be retried")
+	}
+
+	// second attempt is the last
+	_, err = m.StartStage("stage-1", false)
+	if err != nil {
+		t.Fatalf("Stage should be able to start again after failing")
+	}
+	res, _ = m.FailStage("stage-1", false)
+	if res.Retry == nil || !res.Retry.Exhausted || res.Retry.Attempt != 2 {
+		t.Fatalf("Stage should have exhausted its attempts after failing twice")
+	}
+	if len(m.Next()) != 0 {
+		t.Fatalf("Stage that has exhausted its attempts should not be returned by Next")
+	}
+	_, err = m.StartStage("stage-1", false)
+	if err == nil {
+		t.Fatalf("Stage that has exhausted its attempts should not be able to start")
+	}
+}
+
+func TestMission_FailStage_RetryBackoff(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_retry.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	m.StartStage("stage-1", false)
+	m.FinishStage("stage-1", false)
+	m.StartStage("stage-2", false)
+	res, _ := m.FailStage("stage-2", false)
+	if len(res.Next) != 0 || len(m.Next()) != 0 {
+		t.Fatalf("Stage should not be eligible to run until its backoff has elapsed")
+	}
+	_, err := m.StartStage("stage-2", false)
+	if err == nil {
+		t.Fatalf("Stage should not be able to start until its backoff has elapsed")
+	}
+
+	s, _ := m.GetStage("stage-2")
+	s.End = s.End.Add(-2 * time.Hour) // pretend that the stage failed long ago
+	if next := m.Next(); len(next) != 1 || next[0] != "stage-2" {
+		t.Fatalf("Stage should be eligible to run after its backoff has elapsed")
+	}
+	m.StartStage("stage-2", false)
+	m.FailStage("stage-2", false)
+	if d := s.Retry.backoff(s.Attempts); d != 2*time.Hour {
+		t.Fatalf("Exponential backoff after the second attempt should be 2h, got %v", d)
+	}
+}
+
+func TestMission_RetriesDue(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_retry.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	m.StartStage("stage-1", false)
+	m.FinishStage("stage-1", false)
+	m.StartStage("stage-2", false)
+	m.FailStage("stage-2", false)
+	if due := m.RetriesDue(); len(due) != 0 {
+		t.Fatalf("Stage should not be due to be retried until its backoff has elapsed, got %v", due)
+	}
+
+	s, _ := m.GetStage("stage-2")
+	s.End = s.End.Add(-2 * time.Hour) // pretend that the stage failed long ago
+	s.Dispatch = &Dispatch{Status: DispatchDelivered, Time: s.End.Add(-time.Minute)}
+	if due := m.RetriesDue(); len(due) != 1 || due[0] != "stage-2" {
+		t.Fatalf("Stage should be due to be retried after its backoff has elapsed, got %v", due)
+	}
+	m.SetDispatch("stage-2", &Dispatch{Status: DispatchPending, Time: time.Now()})
+	if due := m.RetriesDue(); len(due) != 0 {
+		t.Fatalf("Stage that has been dispatched since it failed should not be due to be retried, got %v", due)
+	}
+}
+
+func TestMission_FailStage_Fatal(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_retry.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	m.StartStage("stage-1", false)
+	res, _ := m.FailStage("stage-1", true)
+	if res.Retry == nil || !res.Retry.Exhausted {
+		t.Fatalf("Fatal failures should not be retried")
+	}
+	_, err := m.StartStage("stage-1", false)
+	if err == nil {
+		t.Fatalf("Stage that failed fatally should not be able to start")
+	}
+}
+
+func TestMission_FailOverdueStages(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_retry.json")
+	m, _ := NewFromJSON(data)
+	s, _ := m.GetStage("stage-1")
+	s.Timeout = "1m"
+	err := m.Validate()
+	if err != nil {
+		t.Fatalf(`Test mission didn't pass validation.`)
+	}
+
+	m.StartStage("stage-1", false)
+	if m.HasOverdueStages(time.Now()) {
+		t.Fatalf("Stage should not be overdue before its timeout has elapsed")
+	}
+	timedOut := m.FailOverdueStages(time.Now().Add(2 * time.Minute))
+	if len(timedOut) != 1 || s.State != failed || s.Reason == "" {
+		t.Fatalf("Stage should have failed due to timing out")
+	}
+	if !s.willRetry() {
+		t.Fatalf("Stage that timed out should be retried according to its retry policy")
+	}
+}
+
+func TestMission_FailOverdueStages_MissionTimeout(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission.json")
+	m, _ := NewFromJSON(data)
+	m.Timeout = "1h"
+	m.Start = time.Now()
+	err := m.Validate()
+	if err != nil {
+		t.Fatalf(`Test mission didn't pass validation.`)
+	}
+
+	m.StartStage("stage-1", false)
+	later := time.Now().Add(2 * time.Hour)
+	if !m.HasOverdueStages(later) {
+		t.Fatalf("Mission should have overdue stages after its timeout has elapsed")
+	}
+	m.FailOverdueStages(later)
+	s1, _ := m.GetStage("stage-1")
+	s2, _ := m.GetStage("stage-2")
+	if s1.State != failed || !s1.Fatal {
+		t.Fatalf("Stage in progress should have failed fatally due to the mission timing out")
+	}
+	if s2.State != excluded {
+		t.Fatalf("Stage that hadn't started should be excluded due to the mission timing out")
+	}
+	if m.HasOverdueStages(later) {
+		t.Fatalf("Mission should not have any overdue stages after they have been failed")
+	}
+	if !m.isComplete || !m.End.Equal(later) || !m.TimedOut() {
+		t.Fatalf("Mission should have ended when its timeout elapsed, even though it has a failed stage")
+	}
+	if _, err := m.StartStage("stage-1", false); err == nil {
+		t.Fatalf("Stages should not be started after the mission has timed out")
+	}
+}
+
+func TestMission_TriggerRules_OneFailed(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_trigger_rules.json")
+	m, _ := NewFromJSON(data)
+	err := m.Validate()
+	if err != nil {
+		t.Fatalf(`Test mission didn't pass validation.`)
+	}
+
+	for _, stageName := range []string{"extract", "transform"} {
+		m.StartStage(stageName, false)
+		m.FinishStage(stageName, false)
+	}
+	m.StartStage("load", false)
+	_, err = m.StartStage("cleanup", false)
+	if err == nil {
+		t.Fatalf("Stage with one_failed trigger rule should not start before an upstream stage fails")
+	}
+
+	res, _ := m.FailStage("load", false)
+	if len(res.Next) != 2 || !contains(res.Next, "cleanup") || !contains(res.Next, "report") {
+		t.Fatalf("Stages with one_failed and all_done trigger rules should be next after upstream stage fails, got %v", res.Next)
+	}
+	_, err = m.StartStage("cleanup", false)
+	if err != nil {
+		t.Fatalf("Stage with one_failed trigger rule should start after upstream stage fails: %v", err)
+	}
+}
+
+func TestMission_TriggerRules_Unsatisfiable(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_trigger_rules.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	for _, stageName := range []string{"extract", "transform", "load"} {
+		m.StartStage(stageName, false)
+		m.FinishStage(stageName, false)
+	}
+	s, _ := m.GetStage("cleanup")
+	if s.State != skipped {
+		t.Fatalf("Stage with one_failed trigger rule should be skipped when all upstream stages have finished")
+	}
+	m.StartStage("report", false)
+	res, _ := m.FinishStage("report", false)
+	if !res.IsComplete {
+		t.Fatalf("Mission should be complete")
+	}
+}
+
+func TestMission_TriggerRules_SkippedChain(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_trigger_rules.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	// skipping transform means that load should depend on extract instead
+	m.SkipStage("transform")
+	_, err := m.StartStage("load", false)
+	if err == nil {
+		t.Fatalf("Stage should not start when the upstream of a skipped stage is unfinished")
+	}
+	m.StartStage("extract", false)
+	res, _ := m.FinishStage("extract", false)
+	if len(res.Next) != 1 || res.Next[0] != "load" {
+		t.Fatalf("Stage downstream of skipped stage should be next once the skipped stage's upstream finishes")
+	}
+}
+
+func TestMission_FailStage_OnFailureHandler(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_handlers.json")
+	m, _ := NewFromJSON(data)
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Test mission didn't pass validation: %v", err)
+	}
+
+	m.StartStage("extract", false)
+	res, _ := m.FinishStage("extract", false)
+	if len(res.Next) != 1 || res.Next[0] != "load" {
+		t.Fatalf("Handler stages should not be next before the stage they handle has run, got %v", res.Next)
+	}
+	if _, err := m.StartStage("rollback", false); err == nil {
+		t.Fatalf("on_failure handler should not start before the stage it handles fails")
+	}
+
+	m.StartStage("load", false)
+	res, _ = m.FailStage("load", false)
+	if len(res.Next) != 1 || res.Next[0] != "rollback" {
+		t.Fatalf("on_failure handler should be next after the stage it handles fails, got %v", res.Next)
+	}
+	if s, _ := m.GetStage("notify"); s.State != ready {
+		t.Fatalf("on_success handler should not be skipped while the failed stage can be restarted manually")
+	}
+
+	m.StartStage("rollback", false)
+	res, _ = m.FinishStage("rollback", false)
+	if len(res.Next) != 0 {
+		t.Fatalf("Finalizer should not be next while a stage has failed and could be restarted, got %v", res.Next)
+	}
+}
+
+func TestMission_FinishStage_OnSuccessHandlerAndFinalizer(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_handlers.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	m.StartStage("extract", false)
+	m.FinishStage("extract", false)
+	m.StartStage("load", false)
+	res, _ := m.FinishStage("load", false)
+	if len(res.Next) != 1 || res.Next[0] != "notify" {
+		t.Fatalf("on_success handler should be next after the stage it handles finishes, got %v", res.Next)
+	}
+	if s, _ := m.GetStage("rollback"); s.State != skipped {
+		t.Fatalf("on_failure handler should be skipped when the stage it handles finishes, got %v", s.State)
+	}
+
+	m.StartStage("notify", false)
+	res, _ = m.FinishStage("notify", false)
+	if len(res.Next) != 1 || res.Next[0] != "cleanup" || res.IsComplete {
+		t.Fatalf("Finalizer should be next once all other stages are done, got %v", res.Next)
+	}
+	m.StartStage("cleanup", false)
+	res, _ = m.FinishStage("cleanup", false)
+	if !res.IsComplete {
+		t.Fatalf("Mission should be complete once the finalizer has finished")
+	}
+}
+
+func TestMission_Validate_Handlers(t *testing.T) {
+	cyclic := New("cyclic", []*Stage{
+		{Name: "a", Downstream: []string{"b"}},
+		{Name: "b", OnFailure: []string{"a"}},
+	})
+	if err := cyclic.Validate(); err == nil {
+		t.Fatalf("Mission with a cycle through an on_failure link should not be valid")
+	}
+
+	undefined := New("undefined", []*Stage{{Name: "a", OnSuccess: []string{"b"}}})
+	if err := undefined.Validate(); err == nil {
+		t.Fatalf("Mission with an undefined handler stage should not be valid")
+	}
+
+	linkedFinalizer := New("linked-finalizer", []*Stage{
+		{Name: "a"},
+		{Name: "b", Upstream: []string{"a"}, Finalizer: true},
+	})
+	if err := linkedFinalizer.Validate(); err == nil {
+		t.Fatalf("Mission with a finalizer that has upstream stages should not be valid")
+	}
+}
+
+func TestMission_MapStage(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_map.json")
+	m, _ := NewFromJSON(data)
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Test mission didn't pass validation: %v", err)
+	}
+
+	m.StartStage("list", false)
+	res, _ := m.FinishStage("list", false)
+	if len(res.Next) != 2 || res.Next[0] != "load[0]" || res.Next[1] != "load[1]" {
+		t.Fatalf("Map stage should be expanded into one instance per item, got %v", res.Next)
+	}
+	instance, _ := m.GetStage("load[1]")
+	if instance.Params["files"] != "b.csv" || instance.Params["table"] != "raw" {
+		t.Fatalf("Map stage instance should be given its item as a param, got %v", instance.Params)
+	}
+	if _, err := m.StartStage("load", false); err == nil {
+		t.Fatalf("Map stage should not be started directly")
+	}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Mission with expanded map stage didn't pass validation: %v", err)
+	}
+
+	m.StartStage("load[0]", false)
+	res, _ = m.FinishStage("load[0]", false)
+	if len(res.Next) != 1 || res.Next[0] != "load[1]" {
+		t.Fatalf("Downstream of map stage should wait for all instances, got %v", res.Next)
+	}
+	m.StartStage("load[1]", false)
+	res, _ = m.FinishStage("load[1]", false)
+	if len(res.Next) != 1 || res.Next[0] != "report" {
+		t.Fatalf("Downstream of map stage should be next once all instances have finished, got %v", res.Next)
+	}
+	if s, _ := m.GetStage("load"); s.State != finished {
+		t.Fatalf("Map stage should be finished once all instances have finished, got %v", s.State)
+	}
+}
+
+func TestMission_MapStage_InstanceFails(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_map.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	m.StartStage("list", false)
+	m.FinishStage("list", false)
+	m.StartStage("load[0]", false)
+	m.FinishStage("load[0]", false)
+	m.StartStage("load[1]", false)
+	m.FailStage("load[1]", true)
+
+	s, _ := m.GetStage("load")
+	if s.State != failed || !s.Fatal {
+		t.Fatalf("Map stage should fail once all instances are done and one has failed, got %v", s.State)
+	}
+	if s, _ := m.GetStage("report"); s.State != skipped {
+		t.Fatalf("Downstream of failed map stage should be skipped, got %v", s.State)
+	}
+}
+
+func TestMission_ExpandMapStages_MissingList(t *testing.T) {
+	m := New("missing-list", []*Stage{{Name: "load", MapOver: "files"}})
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Test mission didn't pass validation: %v", err)
+	}
+	if err := m.ExpandMapStages(); err == nil {
+		t.Fatalf("Map stage without a list should not be expanded")
+	}
+
+	bracketed := New("bracketed", []*Stage{{Name: "load[0]"}})
+	if err := bracketed.Validate(); err == nil {
+		t.Fatalf("Stage names that could clash with map stage instances should not be valid")
+	}
+}
+
+func TestMission_SubPlan(t *testing.T) {
+	recursive := New("plan-a", []*Stage{{Name: "a", Service: "plan:plan-a"}})
+	if err := recursive.Validate(); err == nil {
+		t.Fatalf("Plan that runs itself as a sub-plan should not be valid")
+	}
+
+	m := New("plan-b", []*Stage{{Name: "a", Service: "plan:plan-a"}, {Name: "b", Upstream: []string{"a"}}})
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Test mission didn't pass validation: %v", err)
+	}
+	if err := m.SetChild("a", "m1"); err == nil {
+		t.Fatalf("Child mission should not be linked to a stage that hasn't started")
+	}
+	m.StartStage("a", false)
+	if err := m.SetChild("a", "m1"); err != nil || !m.IsRunningChild("a", "m1") {
+		t.Fatalf("Child mission should be linked to a started stage: %v", err)
+	}
+	if hasFailed, _ := m.HasFailed(); hasFailed {
+		t.Fatalf("Mission with a stage in progress has not failed")
+	}
+	m.FailStage("a", true)
+	if hasFailed, fatal := m.HasFailed(); !hasFailed || !fatal {
+		t.Fatalf("Mission that can't progress because of a fatal failure should have failed")
+	}
+
+	saved := map[string][]string{"plan-a": {"plan-c"}, "plan-c": {"plan-d", "plan-b"}, "plan-d": {"plan-d"}}
+	subPlans := func(plan string) []string { return saved[plan] }
+	err := m.ValidateSubPlans(subPlans)
+	if err == nil || !strings.Contains(err.Error(), "plan-b -> plan-a -> plan-c -> plan-b") {
+		t.Fatalf("Plan that runs itself through a chain of other plans should not be valid, got %v", err)
+	}
+	delete(saved, "plan-c")
+	if err := m.ValidateSubPlans(subPlans); err != nil {
+		t.Fatalf("Plan without a chain back to itself should be valid, got %v", err)
+	}
+}
+
+func TestMission_StartStage_RendersOutputs(t *testing.T) {
+	m := New("outputs", []*Stage{
+		{Name: "extract"},
+		{Name: "load", Upstream: []string{"extract"}, Params: map[string]interface{}{
+			"rows":    "{{ stages.extract.outputs.row_count }}",
+			"message": "loading {{ stages.extract.outputs.row_count }} rows",
+			"missing": "{{ stages.extract.outputs.nothing }}",
+		}},
+	})
+	m.Validate()
+
+	m.StartStage("extract", false)
+	if err := m.SetOutputs("extract", map[string]interface{}{"row_count": 42}); err != nil {
+		t.Fatalf("Failed to set outputs of started stage: %v", err)
+	}
+	m.FinishStage("extract", false)
+	if _, err := m.StartStage("load", false); err == nil {
+		t.Fatalf("Stage should not start when its params refer to an output that doesn't exist")
+	}
+
+	s, _ := m.GetStage("load")
+	delete(s.Params, "missing")
+	res, err := m.StartStage("load", false)
+	if err != nil {
+		t.Fatalf("Failed to start stage: %v", err)
+	}
+	if res.Params["rows"] != 42 || res.Params["message"] != "loading 42 rows" {
+		t.Fatalf("Stage params should be rendered using upstream outputs, got %v", res.Params)
+	}
+	if s.Params["rows"] != "{{ stages.extract.outputs.row_count }}" {
+		t.Fatalf("Stored stage params should not be rendered, so that they can be rendered again on retry")
+	}
+}
+
+func TestMission_MapStage_OverUpstreamOutput(t *testing.T) {
+	m := New("map-outputs", []*Stage{
+		{Name: "list"},
+		{Name: "load", Upstream: []string{"list"}, MapOver: "partitions"},
+	})
+	m.Validate()
+
+	m.StartStage("list", false)
+	m.SetOutputs("list", map[string]interface{}{"partitions": []interface{}{"2024-01", "2024-02", "2024-03"}})
+	res, _ := m.FinishStage("list", false)
+	if len(res.Next) != 3 {
+		t.Fatalf("Map stage should be expanded over its upstream stage's output, got %v", res.Next)
+	}
+}
+
+func TestMission_StartStage_RendersParams(t *testing.T) {
+	m := New("templates", []*Stage{
+		{Name: "load", Params: map[string]interface{}{
+			"table":  "sales_{{ params.env }}",
+			"date":   "{{ mission.start | date \"2006-01-02\" }}",
+			"id":     "{{ mission.id }}",
+			"region": "{{ params.region | default \"eu\" }}",
+			"env":    "test",
+		}},
+	})
+	m.Params = map[string]interface{}{"env": "prod", "day": "{{ mission.start | date \"Monday\" }}"}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Test mission didn't pass validation: %v", err)
+	}
+	m.Id = "m1"
+	m.Start = time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	res, err := m.StartStage("load", false)
+	if err != nil {
+		t.Fatalf("Failed to start stage: %v", err)
+	}
+	expected := map[string]interface{}{"table": "sales_prod", "date": "2024-03-01", "id": "m1", "region": "eu", "env": "test", "day": "Friday"}
+	for k, v := range expected {
+		if res.Params[k] != v {
+			t.Fatalf("Param '%v' should be rendered as '%v', got '%v'", k, v, res.Params[k])
+		}
+	}
+}
+
+func TestMission_Validate_Templates(t *testing.T) {
+	invalid := []map[string]interface{}{
+		{"a": "{{ params }}"},
+		{"a": "{{ mission.nothing }}"},
+		{"a": "{{ stages.missing.outputs.x }}"},
+		{"a": "{{ stages.load.x }}"},
+		{"a": "{{ params.date | upper }}"},
+		{"a": "{{ params.date | date 2006 }}"},
+	}
+	for _, params := range invalid {
+		m := New("invalid-templates", []*Stage{{Name: "load", Params: params}})
+		if err := m.Validate(); err == nil {
+			t.Fatalf("Mission with param %v should not be valid", params)
+		}
+	}
+
+	m := New("invalid-mission-params", []*Stage{{Name: "load"}})
+	m.Params = map[string]interface{}{"a": "{{ params.b }}"}
+	if err := m.Validate(); err == nil {
+		t.Fatalf("Mission params should not be able to refer to other params")
+	}
+
+	// expressions that don't refer to params, mission or stages belong to other templating languages, e.g. dbt
+	sql := "select * from {{ ref('orders') }} where region = '{{ params.region }}'"
+	m = New("other-templates", []*Stage{{Name: "load", Params: map[string]interface{}{"sql": sql, "var": "{{ var.x }}"}}})
+	m.Params = map[string]interface{}{"region": "eu", "model": "{{ ref('customers') }}"}
+	if err := m.Validate(); err != nil {
+		t.Fatalf("Expressions that aren't templates should be allowed: %v", err)
+	}
+	res, err := m.StartStage("load", false)
+	if err != nil {
+		t.Fatalf("Failed to start stage: %v", err)
+	}
+	if res.Params["sql"] != "select * from {{ ref('orders') }} where region = 'eu'" || res.Params["var"] != "{{ var.x }}" || res.Params["model"] != "{{ ref('customers') }}" {
+		t.Fatalf("Expressions that aren't templates should be left as they are, got %v", res.Params)
+	}
+}
+
+func TestMission_ResetStage(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_handlers.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	for _, stage := range []string{"extract", "load", "notify", "cleanup"} {
+		m.StartStage(stage, false)
+		m.FinishStage(stage, false)
+	}
+	if !m.isComplete {
+		t.Fatalf("Test mission should be complete")
+	}
+	if _, err := m.ResetStage("rollback"); err != nil {
+		t.Fatalf("Skipped stage should be able to be reset: %v", err)
+	}
+
+	res, err := m.ResetStage("load")
+	if err != nil {
+		t.Fatalf("Failed to reset stage: %v", err)
+	}
+	if m.isComplete || !m.End.IsZero() {
+		t.Fatalf("Mission should be reopened when a stage is reset")
+	}
+	if len(res.Next) != 1 || res.Next[0] != "load" {
+		t.Fatalf("Reset stage should be next, got %v", res.Next)
+	}
+	for _, stage := range []string{"load", "rollback", "notify", "cleanup"} {
+		s, _ := m.GetStage(stage)
+		if s.State != ready || !s.Start.IsZero() || s.Attempts != 0 {
+			t.Fatalf("Stage '%v' should be reset, got %v", stage, s.State)
+		}
+	}
+	if s, _ := m.GetStage("extract"); s.State != finished {
+		t.Fatalf("Stage upstream of reset stage should not be reset, got %v", s.State)
+	}
+	if _, err := m.ResetStage("load"); err == nil {
+		t.Fatalf("Stage that is ready should not be able to be reset")
+	}
+}
+
+func TestMission_ResetStage_MapStage(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_map.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	m.StartStage("list", false)
+	m.FinishStage("list", false)
+	for _, stage := range []string{"load[0]", "load[1]", "report"} {
+		m.StartStage(stage, false)
+		m.FinishStage(stage, false)
+	}
+
+	// resetting an instance only resets the instance and the stages downstream of the map stage
+	m.ResetStage("load[1]")
+	if s, _ := m.GetStage("load"); s.State != started {
+		t.Fatalf("Map stage should be in progress when an instance is reset, got %v", s.State)
+	}
+	if s, _ := m.GetStage("report"); s.State != ready {
+		t.Fatalf("Stage downstream of map stage should be reset, got %v", s.State)
+	}
+
+	// resetting the upstream stage removes the instances so that they can be expanded again
+	m.ResetStage("list")
+	if len(m.Stages) != 3 {
+		t.Fatalf("Instances of reset map stage should be removed, got %v stages", len(m.Stages))
+	}
+}
+
+func TestMission_PauseResume(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_handlers.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	m.StartStage("extract", false)
+	if _, err := m.Pause(); err != nil {
+		t.Fatalf("Failed to pause mission: %v", err)
+	}
+	if _, err := m.Pause(); err == nil {
+		t.Fatalf("Paused mission should not be able to be paused again")
+	}
+
+	// stages in progress can still be finished, but nothing else can be started
+	res, err := m.FinishStage("extract", false)
+	if err != nil {
+		t.Fatalf("Stage in progress should be able to finish while paused: %v", err)
+	}
+	if len(res.Next) != 0 {
+		t.Fatalf("Paused mission should have no next stages, got %v", res.Next)
+	}
+	if _, err := m.StartStage("load", false); err == nil {
+		t.Fatalf("Stage should not be able to start while the mission is paused")
+	}
+
+	res, err = m.Resume()
+	if err != nil || len(res.Next) != 1 || res.Next[0] != "load" {
+		t.Fatalf("Resumed mission should return next stages, got %v, %v", res.Next, err)
+	}
+	if _, err := m.Resume(); err == nil {
+		t.Fatalf("Mission that isn't paused should not be able to be resumed")
+	}
+	if _, err := m.StartStage("load", false); err != nil {
+		t.Fatalf("Stage should be able to start once the mission is resumed: %v", err)
+	}
+}
+
+func TestMission_Cancel(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_handlers.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	m.StartStage("extract", false)
+	m.FinishStage("extract", false)
+	m.StartStage("load", false)
+
+	res, err := m.Cancel("bad data", "operator")
+	if err != nil || !res.IsComplete {
+		t.Fatalf("Cancelled mission should be complete: %v", err)
+	}
+	if m.Cancellation == nil || m.Cancellation.Reason != "bad data" || m.Cancellation.Actor != "operator" {
+		t.Fatalf("Cancellation reason and actor should be recorded, got %v", m.Cancellation)
+	}
+	for _, stage := range []string{"rollback", "notify", "cleanup"} {
+		if s, _ := m.GetStage(stage); s.State != excluded {
+			t.Fatalf("Stage '%v' should be excluded when the mission is cancelled, got %v", stage, s.State)
+		}
+	}
+	if s, _ := m.GetStage("extract"); s.State != finished {
+		t.Fatalf("Finished stage should not be changed when the mission is cancelled, got %v", s.State)
+	}
+	if _, err := m.FinishStage("load", false); err == nil {
+		t.Fatalf("Stage should not be able to finish once the mission is cancelled")
+	}
+	if _, err := m.Cancel("", ""); err == nil {
+		t.Fatalf("Cancelled mission should not be able to be cancelled again")
+	}
+
+	res, err = m.ResetStage("notify")
+	if err != nil || res.IsComplete || !m.End.IsZero() {
+		t.Fatalf("Resetting a stage should reopen the cancelled mission: %v", err)
+	}
+	if m.Cancellation != nil {
+		t.Fatalf("Reopened mission should no longer be cancelled, got %v", m.Cancellation)
+	}
+}
+
+func TestMission_StageHistory(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_handlers.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	m.Annotate("operator", "")
+	m.StartStage("extract", false)
+	m.FinishStage("extract", false)
+	m.StartStage("load", false)
+	m.Annotate("load-service", "connection refused")
+	m.FailStage("load", false)
+	m.Annotate("operator", "retrying")
+	m.StartStage("load", false)
+
+	s, _ := m.GetStage("load")
+	if len(s.History) != 3 {
+		t.Fatalf("Stage should have 3 transitions, got %v", s.History)
+	}
+	fail := s.History[1]
+	if fail.From != started || fail.To != failed || fail.Attempt != 1 || fail.Caller != "load-service" || fail.Message != "connection refused" {
+		t.Fatalf("Failure was not recorded correctly, got '%v'", fail)
+	}
+	if retry := s.History[2]; retry.Attempt != 2 || retry.Message != "retrying" {
+		t.Fatalf("Retry was not recorded correctly, got '%v'", retry)
+	}
+
+	// stages that are skipped automatically are given a reason
+	m.FinishStage("load", false)
+	s, _ = m.GetStage("rollback")
+	if len(s.History) != 1 || s.History[0].To != skipped || s.History[0].Message == "" {
+		t.Fatalf("Automatically skipped stage should have its reason recorded, got %v", s.History)
+	}
+
+	// history is kept when a stage is reset
+	m.ResetStage("load")
+	s, _ = m.GetStage("load")
+	if len(s.History) != 5 || s.History[4].To != ready {
+		t.Fatalf("Reset should be appended to the stage's history, got %v", s.History)
+	}
+}
+
+func TestMission_FailStage_StageError(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_retry.json")
+	m, _ := NewFromJSON(data)
+	m.Validate()
+
+	m.StartStage("stage-1", false)
+	stageError := &StageError{Message: "table not found", Type: "NotFound", Stack: "line 1"}
+	if err := m.SetError("stage-1", stageError); err != nil {
+		t.Fatalf("Failed to set error: %v", err)
+	}
+	m.FailStage("stage-1", false)
+
+	s, _ := m.GetStage("stage-1")
+	if s.Error == nil || s.Error.Stack != "line 1" || s.Reason != "NotFound: table not found" {
+		t.Fatalf("Error should be stored on the stage, got %v", s.Error)
+	}
+	if last := s.History[len(s.History)-1]; last.Message != s.Reason {
+		t.Fatalf("Error should be recorded in the stage's history, got '%v'", last)
+	}
+	if report := m.Report(); !strings.Contains(report, "NotFound: table not found") {
+		t.Fatalf("Error should be shown in the mission report, got:\n%v", report)
+	}
+
+	// the error is cleared when the stage is retried
+	m.StartStage("stage-1", false)
+	if s.Error != nil || s.Reason != "" {
+		t.Fatalf("Error should be cleared when the stage is retried")
+	}
+	if err := m.SetError("stage-2", stageError); err == nil {
+		t.Fatalf("Error should not be able to be set on a stage that hasn't started")
+	}
+}
+
+func TestMission_Export(t *testing.T) {
+	data, _ := os.ReadFile("../tests/test_mission_handlers.json")
+	m, _ := NewFromJSON(data)
+	m.StartStage("extract", false)
+	m.FinishStage("extract", false)
+
+	dot, err := m.Export(FormatDOT, true)
+	if err != nil {
+		t.Fatalf("Failed to export DOT: %v", err)
+	}
+	for _, expected := range []string{`"extract" -> "load";`, `"load" -> "rollback" [style=dashed, label="on_failure"];`, `"extract" [fillcolor="#bbf7d0", tooltip="finished"];`} {
+		if !strings.Contains(dot, expected) {
+			t.Fatalf("DOT graph should contain %v, got:\n%v", expected, dot)
+		}
+	}
+
+	mermaid, _ := m.Export(FormatMermaid, true)
+	for _, expected := range []string{"flowchart LR", "s0 --> s1", "s1 -. on_success .-> s3", "class s0 finished"} {
+		if !strings.Contains(mermaid, expected) {
+			t.Fatalf("Mermaid graph should contain %v, got:\n%v", expected, mermaid)
+		}
+	}
+	if plan, _ := m.Export(FormatMermaid, false); strings.Contains(plan, "classDef") {
+		t.Fatalf("Stages should not be coloured by state when showState is false")
+	}
+
+	svg, _ := m.Export(FormatSVG, true)
+	var doc struct{ XMLName xml.Name }
+	if err := xml.Unmarshal([]byte(svg), &doc); err != nil || doc.XMLName.Local != "svg" || strings.Count(svg, "<rect") != len(m.Stages) {
+		t.Fatalf("SVG graph should be valid XML with one box per stage, got:\n%v", svg)
+	}
+	s0 := m.Stages[0]
+	s0.X, s0.Y = 500, 300
+	if svg, _ = m.Export(FormatSVG, true); !strings.Contains(svg, `<rect x="520" y="320"`) {
+		t.Fatalf("SVG graph should draw stages at their positions, got:\n%v", svg)
+	}
+
+	if _, err := m.Export("png", true); err == nil {
+		t.Fatalf("Unsupported formats should return an error")
+	}
+}
+
+func TestMission_DryRun(t *testing.T) {
+	newMission := func() Mission {
+		return New("dry-run", []*Stage{
+			{Name: "extract", Downstream: []string{"transform", "audit"}},
+			{Name: "transform", Downstream: []string{"load"}},
+			{Name: "audit", Downstream: []string{"load", "report"}},
+			{Name: "load", OnFailure: []string{"rollback"}},
+			{Name: "report"},
+			{Name: "rollback"},
+			{Name: "cleanup", Finalizer: true},
+		})
+	}
+	durations := map[string]time.Duration{"extract": time.Minute, "transform": 10 * time.Minute, "audit": 2 * time.Minute, "load": 5 * time.Minute}
+
+	m := newMission()
+	plan, err := m.DryRun(nil, nil, nil, durations)
+	if err != nil {
+		t.Fatalf("Dry run failed: %v", err)
+	}
+	expectedWaves := "[[extract] [transform audit] [load report] [cleanup]]"
+	if fmt.Sprint(plan.Waves) != expectedWaves {
+		t.Fatalf("Expected waves %v, got %v", expectedWaves, plan.Waves)
+	}
+	if strings.Join(plan.CriticalPath, ",") != "extract,transform,load,cleanup" || plan.EstimatedDuration != "16m0s" {
+		t.Fatalf("Expected critical path through transform taking 16m, got %v (%v)", plan.CriticalPath, plan.EstimatedDuration)
+	}
+	if fmt.Sprint(plan.Skipped) != "[rollback]" {
+		t.Fatalf("on_failure handlers should be skipped, got %v", plan.Skipped)
+	}
+
+	m = newMission()
+	plan, _ = m.DryRun(nil, []string{"transform"}, []string{"report"}, durations)
+	if fmt.Sprint(plan.Excluded) != "[transform load]" || fmt.Sprint(plan.Waves) != "[[extract] [audit] [cleanup]]" {
+		t.Fatalf("Stages downstream of excluded stages should be excluded, got %v and waves %v", plan.Excluded, plan.Waves)
+	}
+
+	m = newMission()
+	plan, _ = m.DryRun([]string{"transform"}, nil, nil, nil)
+	if fmt.Sprint(plan.Waves[0]) != "[transform]" || !strings.Contains(fmt.Sprint(plan.Excluded), "extract") {
+		t.Fatalf("Mission should start from the stage given and exclude its upstream stages, got %v, excluded %v", plan.Waves, plan.Excluded)
+	}
+}
+
+func TestMission_UpstreamDownstream(t *testing.T) {
+	m := New("impact", []*Stage{
+		{Name: "source", Downstream: []string{"clean"}},
+		{Name: "clean", Downstream: []string{"load"}, OnFailure: []string{"alert"}},
+		{Name: "load", Params: map[string]interface{}{"rows": "{{ stages.clean.outputs.rows }}"}},
+		{Name: "alert"},
+	})
+	m.StartStage("source", false)
+
+	downstream, err := m.Downstream("source", 0, true)
+	if err != nil {
+		t.Fatalf("Failed to find downstream stages: %v", err)
+	}
+	if fmt.Sprint(downstream) != "[{clean 1 ready [rows]} {load 2 ready []} {alert 2 ready []}]" {
+		t.Fatalf("Unexpected downstream stages: %v", downstream)
+	}
+	if limited, _ := m.Downstream("source", 1, false); len(limited) != 1 || limited[0].State != "" {
+		t.Fatalf("Only stages within the depth given should be returned, without states, got %v", limited)
+	}
+
+	upstream, _ := m.Upstream("alert", 0, true)
+	if fmt.Sprint(upstream) != "[{clean 1 ready [rows]} {source 2 started []}]" {
+		t.Fatalf("Unexpected upstream stages: %v", upstream)
+	}
+	if _, err := m.Upstream("missing", 0, false); err == nil {
+		t.Fatalf("Stage that doesn't exist should return an error")
+	}
+}
+
+func TestMission_Layout(t *testing.T) {
+	// defined in an order where the links from a and b cross
+	m := New("layout", []*Stage{
+		{Name: "a", Downstream: []string{"d"}},
+		{Name: "b", Downstream: []string{"c"}},
+		{Name: "c", Downstream: []string{"e"}},
+		{Name: "d", Downstream: []string{"e"}},
+		{Name: "e"},
+	})
+
+	layout := m.Layout(nil)
+	if len(layout) != len(m.Stages) {
+		t.Fatalf("Every stage should have a position, got %v", layout)
+	}
+	for _, l := range m.links() {
+		if layout[l.from.Name].X >= layout[l.to.Name].X {
+			t.Fatalf("Stage '%v' should be to the left of '%v', got %v", l.from.Name, l.to.Name, layout)
+		}
+	}
+	if (layout["a"].Y-layout["b"].Y)*(layout["d"].Y-layout["c"].Y) < 0 {
+		t.Fatalf("Links from a and b should not cross, got %v", layout)
+	}
+
+	pinned := m.Layout(map[string]Position{"e": {1000, 500}, "missing": {0, 0}})
+	if pinned["e"] != (Position{1000, 500}) || pinned["a"] != layout["a"] {
+		t.Fatalf("Pinned stages should keep their position without moving other stages, got %v", pinned)
+	}
+	if _, ok := pinned["missing"]; ok {
+		t.Fatalf("Stages that don't exist should not be given a position")
+	}
+
+	m.SetPositions(pinned)
+	if e, _ := m.GetStage("e"); e.X != 1000 || e.Y != 500 {
+		t.Fatalf("Stage positions should be set, got %v, %v", e.X, e.Y)
+	}
+	if positions := m.Positions(); positions["e"] != pinned["e"] {
+		t.Fatalf("Stage positions should be returned, got %v", positions)
+	}
+
+	// map stage instances are laid out between the map stage and its upstream stages once they are created
+	mapped := New("layout-map", []*Stage{
+		{Name: "list"},
+		{Name: "load", MapOver: "files", Upstream: []string{"list"}},
+		{Name: "report", Upstream: []string{"load"}},
+	})
+	mapped.Params = map[string]interface{}{"files": []interface{}{"a", "b", "c"}}
+	mapped.Validate()
+	mapped.SetPositions(mapped.Layout(map[string]Position{"report": {900, 900}}))
+	mapped.StartStage("list", false)
+	mapped.FinishStage("list", false)
+	list, _ := mapped.GetStage("list")
+	load, _ := mapped.GetStage("load")
+	instances := map[Position]bool{}
+	for _, i := range mapped.instances(load) {
+		if i.X <= list.X || i.X >= load.X {
+			t.Fatalf("Instance '%v' should be between its upstream stage and its map stage, got %v", i.Name, mapped.Positions())
+		}
+		instances[Position{i.X, i.Y}] = true
+	}
+	if len(instances) != 3 {
+		t.Fatalf("Every instance should have its own position, got %v", mapped.Positions())
+	}
+	if report, _ := mapped.GetStage("report"); report.X != 900 || report.Y != 900 {
+		t.Fatalf("Pinned stages should keep their position when map stages are expanded, got %v", mapped.Positions())
+	}
+}
+
+func TestMission_Lease(t *testing.T) {
+	m := New("lease", []*Stage{
+		{Name: "extract", Downstream: []string{"load"}},
+		{Name: "load"},
+	})
+	now := time.Now()
+
+	res, err := m.LeaseStage("extract", "worker-1", now.Add(time.Minute))
+	if err != nil || !res.Success {
+		t.Fatalf("Failed to lease stage: %v", err)
+	}
+	s, _ := m.GetStage("extract")
+	if s.State != started || s.Lease == nil || s.Lease.Worker != "worker-1" || s.History[0].Caller != "worker-1" {
+		t.Fatalf("Leased stage should be started by the worker, got %v, %+v", s.State, s.Lease)
+	}
+	if _, err := m.LeaseStage("extract", "worker-2", now.Add(time.Minute)); err == nil {
+		t.Fatalf("Stages that have already been leased should not be leased again")
+	}
+	if _, err := m.LeaseStage("load", "worker-2", now.Add(time.Minute)); err == nil {
+		t.Fatalf("Stages that aren't ready should not be leased")
+	}
+
+	if err := m.RenewLease("extract", "worker-2", now.Add(2*time.Minute)); err == nil {
+		t.Fatalf("Only the worker that holds the lease should be able to renew it")
+	}
+	if err := m.RenewLease("extract", "worker-1", now.Add(2*time.Minute)); err != nil || !s.Lease.Expiry.Equal(now.Add(2*time.Minute)) {
+		t.Fatalf("Failed to renew lease: %v", err)
+	}
+
+	if err := m.CheckLease("extract", "worker-1"); err != nil {
+		t.Fatalf("The worker that holds the lease should be able to update the stage: %v", err)
+	}
+	if m.CheckLease("extract", "worker-2") == nil {
+		t.Fatalf("Only the worker that holds the lease should be able to update the stage")
+	}
+	if err := m.CheckLease("extract", ""); err != nil {
+		t.Fatalf("Callers that aren't workers should be able to update leased stages: %v", err)
+	}
+
+	if m.HasExpiredLeases(now.Add(time.Minute)) {
+		t.Fatalf("Renewed lease should not have expired")
+	}
+	released, exhausted := m.ReleaseExpiredLeases(now.Add(3 * time.Minute))
+	if len(released) != 1 || len(exhausted) != 0 || s.State != ready || s.Lease != nil || s.Attempts != 1 {
+		t.Fatalf("Stage with an expired lease should be returned to ready and keep its attempt, got %v, %v, %v", released, s.State, s.Attempts)
+	}
+	if err := m.RenewLease("extract", "worker-1", now.Add(4*time.Minute)); err == nil {
+		t.Fatalf("Expired leases should not be renewed")
+	}
+	if m.CheckLease("extract", "worker-1") == nil {
+		t.Fatalf("Workers should not be able to update stages after their lease has expired")
+	}
+	if err := m.CheckLease("load", ""); err != nil {
+		t.Fatalf("Stages that aren't leased should be able to be updated without a worker: %v", err)
+	}
+
+	// leases end when the stage finishes, so finished stages are never released
+	m.LeaseStage("extract", "worker-2", now.Add(-time.Minute))
+	m.FinishStage("extract", false)
+	if m.HasExpiredLeases(now) || s.Lease != nil {
+		t.Fatalf("Finished stages should not have leases")
+	}
+
+	// resetting a stage removes its lease and dispatch, so that it can be updated without the previous worker
+	m.SetDispatch("extract", &Dispatch{Status: DispatchDelivered, Attempts: 1, Time: now})
+	s.Lease = &Lease{Worker: "worker-2", Expiry: now}
+	m.ResetStage("extract")
+	if s.Lease != nil || s.Dispatch != nil {
+		t.Fatalf("Reset stages should have no lease or dispatch, got %+v, %+v", s.Lease, s.Dispatch)
+	}
+	if m.CheckLease("extract", "worker-2") == nil {
+		t.Fatalf("Workers should not be able to update reset stages that they haven't leased")
+	}
+}
+
+func TestMission_Lease_RetryPolicy(t *testing.T) {
+	m := New("lease-retry", []*Stage{
+		{Name: "extract", Retry: &RetryPolicy{MaxAttempts: 2}},
+	})
+	s, _ := m.GetStage("extract")
+	now := time.Now()
+
+	// each expired lease uses up one of the stage's attempts
+	m.LeaseStage("extract", "worker-1", now.Add(-time.Minute))
+	released, exhausted := m.ReleaseExpiredLeases(now)
+	if len(released) != 1 || len(exhausted) != 0 || s.State != ready || s.Attempts != 1 {
+		t.Fatalf("Stage with attempts left should be returned to ready, got %v, %v, %v", released, exhausted, s.State)
+	}
+	m.LeaseStage("extract", "worker-2", now.Add(-time.Minute))
+	released, exhausted = m.ReleaseExpiredLeases(now)
+	if len(released) != 0 || len(exhausted) != 1 || s.State != failed || s.Lease != nil || s.Attempts != 2 {
+		t.Fatalf("Stage without attempts left should be failed, got %v, %v, %v", released, exhausted, s.State)
+	}
+	if _, err := m.LeaseStage("extract", "worker-3", now.Add(time.Minute)); err == nil {
+		t.Fatalf("Stages that have used all of their attempts should not be leased again")
+	}
+
+	// failed stages lose their lease
+	m = New("lease-fail", []*Stage{{Name: "extract"}})
+	s, _ = m.GetStage("extract")
+	m.LeaseStage("extract", "worker-1", now.Add(time.Minute))
+	m.FailStage("extract", false)
+	if s.Lease != nil {
+		t.Fatalf("Failed stages should not have leases, got %+v", s.Lease)
+	}
+}
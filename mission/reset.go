@@ -0,0 +1,107 @@
+package mission
+
+import (
+	"fmt"
+	"time"
+)
+
+// ResetStage returns a stage that has finished, failed, been excluded or been skipped to ready, along with every stage
+// downstream of it, so that they can be run again, e.g. to reprocess data after fixing it. This includes on_success and
+// on_failure handlers and finalizers. If the mission is complete then it is reopened, and if it was cancelled then the
+// cancellation is removed, so that a parent mission doesn't treat it as cancelled.
+func (m *Mission) ResetStage(stageName string) (Response, error) {
+	s, err := m.GetStage(stageName)
+	if err != nil {
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	}
+	if s.isMapStage() {
+		return Response{false, nil, m.isComplete, nil, nil}, mapStageChangeError("reset", s)
+	}
+
+	switch s.State {
+	case finished, failed, excluded, skipped:
+		// ok
+	case ready:
+		err := &StageChangeError{fmt.Sprintf("cannot reset stage '%v' because it is already ready", stageName)}
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	case started:
+		err := &StageChangeError{fmt.Sprintf("cannot reset stage '%v' because it is in progress - it must be finished or failed first", stageName)}
+		return Response{false, nil, m.isComplete, nil, nil}, err
+	}
+
+	// find every stage that needs to be reset before changing any state, so that a stage in progress prevents the reset
+	toReset := make(map[*Stage]bool)
+	m.findStagesToReset(s, toReset)
+	for _, f := range m.Stages {
+		if f.Finalizer {
+			toReset[f] = true
+		}
+	}
+	for _, r := range m.Stages {
+		if toReset[r] && r.State == started && !r.isMapStage() {
+			err := &StageChangeError{fmt.Sprintf("cannot reset stage '%v' because downstream stage '%v' is in progress", stageName, r.Name)}
+			return Response{false, nil, m.isComplete, nil, nil}, err
+		}
+	}
+
+	// map stages that are reset lose their instances, which are created again when the map stage is next expanded
+	var stages []*Stage
+	for _, i := range m.Stages {
+		if template, err := m.GetStage(i.MappedFrom); err == nil && toReset[template] {
+			continue
+		}
+		stages = append(stages, i)
+	}
+	m.Stages = stages
+	m.graph = NewGraph(m)
+
+	for r := range toReset {
+		if r.State != ready {
+			message := fmt.Sprintf("upstream stage '%v' was reset", stageName)
+			if r == s {
+				message = m.message
+			}
+			m.setState(r, ready, message)
+		}
+		r.reset()
+	}
+	m.isComplete = false
+	m.End = time.Time{}
+	m.Cancellation = nil
+
+	m.propagate()
+	return Response{true, m.Next(), m.isComplete, nil, nil}, nil
+}
+
+// findStagesToReset recursively finds a stage and every stage downstream of it, following both normal and typed links.
+func (m *Mission) findStagesToReset(s *Stage, toReset map[*Stage]bool) {
+	if toReset[s] {
+		return
+	}
+	toReset[s] = true
+	for _, d := range m.graph.next(s) {
+		if d.isMapStage() && s.MappedFrom == d.Name {
+			// the state of a map stage is set by its instances, so only the stages downstream of it are reset
+			for _, dd := range m.graph.next(d) {
+				m.findStagesToReset(dd, toReset)
+			}
+			continue
+		}
+		m.findStagesToReset(d, toReset)
+	}
+}
+
+// reset returns a stage to the state it was in when the mission was created, apart from its history.
+func (s *Stage) reset() {
+	s.State = ready
+	s.Start = time.Time{}
+	s.End = time.Time{}
+	s.Attempts = 0
+	s.Fatal = false
+	s.Reason = ""
+	s.Error = nil
+	s.Outputs = nil
+	s.Child = ""
+	s.Lease = nil
+	s.Dispatch = nil
+}
@@ -0,0 +1,93 @@
+package mission
+
+import (
+	"fmt"
+	"time"
+)
+
+// RetryPolicy defines how many times a stage may be attempted and how long to wait between attempts.
+// Stages without a retry policy can be retried any number of times, but only by starting them again manually.
+type RetryPolicy struct {
+	MaxAttempts int    `json:"m" name:"max_attempts"`
+	Backoff     string `json:"b" name:"backoff"` // either 'fixed' or 'exponential', defaults to 'fixed'
+	Delay       string `json:"l" name:"delay"`   // time.Duration string, e.g. '30s'
+}
+
+// RetryStatus is included in the response when a stage with a retry policy fails.
+type RetryStatus struct {
+	Attempt     int       `json:"attempt"`
+	MaxAttempts int       `json:"maxAttempts"`
+	Exhausted   bool      `json:"exhausted"`
+	After       time.Time `json:"after"`
+}
+
+const (
+	fixedBackoff       = "fixed"
+	exponentialBackoff = "exponential"
+)
+
+// validate checks that the policy can be used to calculate backoff times.
+func (p *RetryPolicy) validate() error {
+	if p.MaxAttempts < 1 {
+		return fmt.Errorf("max_attempts must be at least 1, got %v", p.MaxAttempts)
+	}
+	switch p.Backoff {
+	case "", fixedBackoff, exponentialBackoff:
+	default:
+		return fmt.Errorf("backoff must be one of '%v' or '%v', got '%v'", fixedBackoff, exponentialBackoff, p.Backoff)
+	}
+	if p.Delay != "" {
+		if d, err := time.ParseDuration(p.Delay); err != nil || d < 0 {
+			return fmt.Errorf("delay '%v' is not a valid duration, e.g. '30s'", p.Delay)
+		}
+	}
+	return nil
+}
+
+// backoff returns the time to wait before the next attempt, given the number of attempts made so far.
+func (p *RetryPolicy) backoff(attempts int) time.Duration {
+	delay, _ := time.ParseDuration(p.Delay) // delay has been validated, and defaults to 0
+	if p.Backoff == exponentialBackoff && attempts > 1 {
+		delay = delay * time.Duration(1<<(attempts-1))
+	}
+	return delay
+}
+
+// retriesExhausted is true if a failed stage can't be attempted again according to its retry policy.
+func (s *Stage) retriesExhausted() bool {
+	if s.Retry == nil {
+		return false
+	}
+	return s.Fatal || s.Attempts >= s.Retry.MaxAttempts
+}
+
+// retryAfter returns the time after which a failed stage can be attempted again.
+func (s *Stage) retryAfter() time.Time {
+	if s.Retry == nil {
+		return s.End
+	}
+	return s.End.Add(s.Retry.backoff(s.Attempts))
+}
+
+// willRetry is true if a failed stage has a retry policy and hasn't exhausted its attempts. Map stages are never
+// retried because their instances are retried instead.
+func (s *Stage) willRetry() bool {
+	return s.State == failed && s.Retry != nil && !s.isMapStage() && !s.retriesExhausted()
+}
+
+// canRetry is true if a failed stage has a retry policy, hasn't exhausted its attempts, and the backoff has elapsed.
+func (s *Stage) canRetry() bool {
+	return s.willRetry() && !time.Now().Before(s.retryAfter())
+}
+
+// retryStatus describes the retry state of a failed stage for use in a Response.
+func (s *Stage) retryStatus() *RetryStatus {
+	if s.Retry == nil {
+		return nil
+	}
+	status := RetryStatus{Attempt: s.Attempts, MaxAttempts: s.Retry.MaxAttempts, Exhausted: s.retriesExhausted()}
+	if !status.Exhausted {
+		status.After = s.retryAfter()
+	}
+	return &status
+}
@@ -6,14 +6,33 @@ import (
 )
 
 type Stage struct {
-	Name       string                 `json:"n" name:"name"`
-	Service    string                 `json:"a" name:"service"`
-	Upstream   []string               `json:"u" name:"upstream"`
-	Downstream []string               `json:"d" name:"downstream"`
-	Params     map[string]interface{} `json:"p" name:"params"`
-	State      state                  `json:"s" name:"state"`
-	Start      time.Time              `json:"t" name:"start"`
-	End        time.Time              `json:"e" name:"end"`
+	Name        string                 `json:"n" name:"name"`
+	Service     string                 `json:"a" name:"service"`
+	Upstream    []string               `json:"u" name:"upstream"`
+	Downstream  []string               `json:"d" name:"downstream"`
+	Params      map[string]interface{} `json:"p" name:"params"`
+	State       state                  `json:"s" name:"state"`
+	Start       time.Time              `json:"t" name:"start"`
+	End         time.Time              `json:"e" name:"end"`
+	Retry       *RetryPolicy           `json:"r,omitempty" name:"retry"`
+	Attempts    int                    `json:"c,omitempty" name:"attempts"`
+	Fatal       bool                   `json:"f,omitempty" name:"fatal"`        // true if the last failure can't be retried
+	Timeout     string                 `json:"o,omitempty" name:"timeout"`      // time.Duration string, e.g. '30m'
+	Reason      string                 `json:"m,omitempty" name:"reason"`       // why the stage failed, if known
+	TriggerRule string                 `json:"w,omitempty" name:"trigger_rule"` // when the stage can run, defaults to 'all_success'
+	OnFailure   []string               `json:"b,omitempty" name:"on_failure"`   // stages that only run if this stage fails
+	OnSuccess   []string               `json:"g,omitempty" name:"on_success"`   // stages that only run if this stage finishes
+	Finalizer   bool                   `json:"z,omitempty" name:"finalizer"`    // true if the stage runs once all other stages are done
+	MapOver     string                 `json:"v,omitempty" name:"map_over"`     // param containing the list this stage is expanded over
+	MappedFrom  string                 `json:"k,omitempty" name:"mapped_from"`  // the map stage that this stage is an instance of
+	Child       string                 `json:"h,omitempty" name:"child"`        // id of the child mission run by a sub-plan stage
+	Outputs     map[string]interface{} `json:"j,omitempty" name:"outputs"`      // values given by the service when the stage finished
+	History     []Transition           `json:"l,omitempty" name:"history"`      // every change in state, oldest first
+	Error       *StageError            `json:"i,omitempty" name:"error"`        // the error given when the stage last failed
+	Dispatch    *Dispatch              `json:"q,omitempty" name:"dispatch"`     // outcome of the API triggering the stage's service
+	Lease       *Lease                 `json:"ls,omitempty" name:"lease"`       // only given if the stage was started by a worker pulling work
+	X           int                    `json:"x,omitempty" name:"x"`            // position when the graph is drawn, see Layout
+	Y           int                    `json:"y,omitempty" name:"y"`
 }
 
 type state int
@@ -0,0 +1,109 @@
+package mission
+
+import (
+	"fmt"
+	"strings"
+)
+
+// stages with a service that starts with this prefix run another plan as a child mission, e.g. 'plan:load-data'
+const subPlanPrefix = "plan:"
+
+// SubPlan returns the name of the plan that the stage runs as a child mission, or an empty string if the stage runs on
+// a service as normal.
+func (s *Stage) SubPlan() string {
+	if !strings.HasPrefix(s.Service, subPlanPrefix) {
+		return ""
+	}
+	return strings.TrimPrefix(s.Service, subPlanPrefix)
+}
+
+// validateSubPlan checks that a sub-plan stage refers to another plan.
+func (m *Mission) validateSubPlan(s *Stage) error {
+	if !strings.HasPrefix(s.Service, subPlanPrefix) {
+		return nil
+	}
+	switch s.SubPlan() {
+	case "":
+		return fmt.Errorf("service '%v' must give the name of a plan, e.g. '%vmy-plan'", s.Service, subPlanPrefix)
+	case m.Name:
+		return fmt.Errorf("service '%v' would run this plan within itself", s.Service)
+	}
+	return nil
+}
+
+// ValidateSubPlans checks that the mission's plan isn't run within itself through a chain of other plans, e.g. plan A
+// runs plan B, which runs plan A, which would create child missions without end. subPlans gives the plans run by the
+// sub-plan stages of another plan, which is normally the saved version of that plan. Direct references to the mission's
+// own plan are checked by Validate.
+func (m *Mission) ValidateSubPlans(subPlans func(plan string) []string) error {
+	for i, s := range m.Stages {
+		if s.SubPlan() == "" || s.SubPlan() == m.Name {
+			continue
+		}
+		visited := map[string]bool{}
+		if chain := findPlan(m.Name, []string{m.Name, s.SubPlan()}, subPlans, visited); chain != nil {
+			p := Problem{"sub_plan_cycle", SeverityError, stagePath(i, "service"), s.Name,
+				fmt.Sprintf("stage '%v' would run this plan within itself through the chain of plans %v", s.Name, strings.Join(chain, " -> "))}
+			return &PlanValidationError{Detail: p.Message, Problems: []Problem{p}}
+		}
+	}
+	return nil
+}
+
+// findPlan follows the sub-plans of the last plan in the chain until the target plan is reached, and returns the chain
+// of plans that leads to it, or nil if it can't be reached.
+func findPlan(target string, chain []string, subPlans func(plan string) []string, visited map[string]bool) []string {
+	plan := chain[len(chain)-1]
+	if visited[plan] {
+		return nil
+	}
+	visited[plan] = true
+	for _, next := range subPlans(plan) {
+		path := append(append([]string{}, chain...), next)
+		if next == target {
+			return path
+		}
+		if found := findPlan(target, path, subPlans, visited); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// SetChild records the id of the child mission that a sub-plan stage is running. The stage must be in progress.
+func (m *Mission) SetChild(stageName string, childId string) error {
+	s, err := m.GetStage(stageName)
+	if err != nil {
+		return err
+	}
+	if s.State != started {
+		return &StageChangeError{fmt.Sprintf("cannot link child mission '%v' to stage '%v' because it is %v, not started", childId, stageName, s.State)}
+	}
+	s.Child = childId
+	return nil
+}
+
+// IsRunningChild is true if the stage is in progress and running the given child mission.
+func (m *Mission) IsRunningChild(stageName string, childId string) bool {
+	s, err := m.GetStage(stageName)
+	return err == nil && s.State == started && s.Child == childId
+}
+
+// HasFailed is true if the mission can't progress because a stage has failed, i.e. the mission isn't complete or
+// paused, no stages are in progress, waiting to be retried, or eligible to run, and at least one stage has failed.
+// fatal is true if any failed stage can never be retried.
+func (m *Mission) HasFailed() (hasFailed bool, fatal bool) {
+	if !m.End.IsZero() || m.Paused || len(m.Next()) > 0 {
+		return false, false
+	}
+	for _, s := range m.Stages {
+		switch {
+		case s.State == started, s.willRetry():
+			return false, false
+		case s.hasFailed():
+			hasFailed = true
+			fatal = fatal || s.failedPermanently()
+		}
+	}
+	return hasFailed, fatal
+}
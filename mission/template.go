@@ -0,0 +1,307 @@
+package mission
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// templatePattern matches a template within a param value, e.g. '{{ stages.extract.outputs.row_count }}'
+var templatePattern = regexp.MustCompile(`{{\s*(.*?)\s*}}`)
+
+// templateRoots are the values that templates can refer to:
+// - params: the mission's params, e.g. '{{ params.date }}'
+// - mission: the mission's id, name and start time, e.g. '{{ mission.id }}'
+// - stages: the outputs of other stages, e.g. '{{ stages.extract.outputs.row_count }}'
+//
+// Expressions that don't refer to one of these aren't templates and are left as they are, so that params can contain
+// other templating languages, e.g. "select * from {{ ref('orders') }}" for dbt.
+var templateRoots = []string{"params", "mission", "stages"}
+
+var missionTemplateFields = []string{"id", "name", "start"}
+
+// templateFilters can be applied to a value with a pipe, e.g. '{{ mission.start | date "2006-01-02" }}'. Every filter
+// takes exactly one argument.
+var templateFilters = map[string]func(value interface{}, defined bool, arg string) (interface{}, error){
+	// date formats a time using a Go time layout. Times given as strings must be in RFC3339 format.
+	"date": func(value interface{}, defined bool, layout string) (interface{}, error) {
+		if !defined {
+			return nil, nil
+		}
+		switch v := value.(type) {
+		case time.Time:
+			return v.Format(layout), nil
+		case string:
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return nil, fmt.Errorf("'%v' is not an RFC3339 time", v)
+			}
+			return t.Format(layout), nil
+		}
+		return nil, fmt.Errorf("'%v' is not a time", value)
+	},
+	// default gives a value to use if the value isn't defined.
+	"default": func(value interface{}, defined bool, fallback string) (interface{}, error) {
+		if !defined {
+			return fallback, nil
+		}
+		return value, nil
+	},
+}
+
+// template is a parsed template expression.
+type template struct {
+	expression string
+	path       []string
+	filters    []templateFilter
+}
+
+type templateFilter struct {
+	name string
+	arg  string
+}
+
+// parseTemplate parses a template expression such as 'mission.start | date "2006-01-02"'.
+func parseTemplate(expression string) (template, error) {
+	parts := strings.Split(expression, "|")
+	t := template{expression: expression, path: templatePath(expression)}
+	if len(t.path) < 2 {
+		return t, fmt.Errorf("template '%v' must refer to a value within '%v'", expression, t.path[0])
+	}
+
+	for _, f := range parts[1:] {
+		name, arg, _ := strings.Cut(strings.TrimSpace(f), " ")
+		if _, ok := templateFilters[name]; !ok {
+			return t, fmt.Errorf("template '%v' uses unknown filter '%v'", expression, name)
+		}
+		unquoted, err := strconv.Unquote(strings.TrimSpace(arg))
+		if err != nil {
+			return t, fmt.Errorf("template '%v' must give filter '%v' a quoted argument", expression, name)
+		}
+		t.filters = append(t.filters, templateFilter{name, unquoted})
+	}
+	return t, nil
+}
+
+// templatePath returns the path of the value that a template expression refers to, ignoring any filters, e.g.
+// [stages extract outputs rows] for 'stages.extract.outputs.rows | default "0"'.
+func templatePath(expression string) []string {
+	return strings.Split(strings.TrimSpace(strings.Split(expression, "|")[0]), ".")
+}
+
+// isTemplate is true if an expression found between braces refers to one of the templateRoots, and should be rendered.
+func isTemplate(expression string) bool {
+	return contains(templateRoots, templatePath(expression)[0])
+}
+
+// validate checks that a template refers to values that can exist in the mission.
+func (t template) validate(m *Mission) error {
+	switch t.path[0] {
+	case "mission":
+		if len(t.path) != 2 || !contains(missionTemplateFields, t.path[1]) {
+			return fmt.Errorf("template '%v' must refer to one of mission.%v", t.expression, strings.Join(missionTemplateFields, ", mission."))
+		}
+	case "stages":
+		if _, err := m.GetStage(t.path[1]); err != nil {
+			return fmt.Errorf("template '%v' refers to stage '%v' which is not defined", t.expression, t.path[1])
+		}
+		if len(t.path) < 4 || t.path[2] != "outputs" {
+			return fmt.Errorf("template '%v' must refer to an output, e.g. 'stages.%v.outputs.<name>'", t.expression, t.path[1])
+		}
+	}
+	return nil
+}
+
+// evaluate finds the value referred to by the template and applies its filters.
+func (t template) evaluate(context map[string]interface{}) (interface{}, error) {
+	var value interface{} = context
+	defined := true
+	for _, key := range t.path {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			defined = false
+			break
+		}
+		if value, ok = object[key]; !ok {
+			defined = false
+			break
+		}
+	}
+	if !defined {
+		value = nil
+	}
+
+	for _, f := range t.filters {
+		var err error
+		if value, err = templateFilters[f.name](value, defined, f.arg); err != nil {
+			return nil, fmt.Errorf("template '%v' could not be rendered: %v", t.expression, err)
+		}
+		defined = value != nil
+	}
+	if !defined {
+		return nil, fmt.Errorf("'%v' is not defined", strings.Join(t.path, "."))
+	}
+	if v, ok := value.(time.Time); ok {
+		return v.Format(time.RFC3339), nil
+	}
+	return value, nil
+}
+
+// findTemplates returns every template expression within a param value, including those within nested objects and
+// lists. Expressions that aren't templates are ignored.
+func findTemplates(value interface{}) []string {
+	var expressions []string
+	switch v := value.(type) {
+	case string:
+		for _, match := range templatePattern.FindAllStringSubmatch(v, -1) {
+			if isTemplate(match[1]) {
+				expressions = append(expressions, match[1])
+			}
+		}
+	case map[string]interface{}:
+		for _, item := range v {
+			expressions = append(expressions, findTemplates(item)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			expressions = append(expressions, findTemplates(item)...)
+		}
+	}
+	return expressions
+}
+
+// validateTemplates checks that all templates within a set of params can be parsed and refer to values that can exist.
+// Mission params are rendered before stage params, so templates within mission params can't refer to other params.
+func (m *Mission) validateTemplates(params map[string]interface{}, allowParams bool) error {
+	for k, v := range params {
+		for _, expression := range findTemplates(v) {
+			t, err := parseTemplate(expression)
+			if err == nil {
+				err = t.validate(m)
+			}
+			if err == nil && !allowParams && t.path[0] == "params" {
+				err = fmt.Errorf("template '%v' can't refer to other params", expression)
+			}
+			if err != nil {
+				return fmt.Errorf("param '%v' is not valid: %v", k, err)
+			}
+		}
+	}
+	return nil
+}
+
+// templateContext returns the values that can be referenced by templates in params, apart from the params themselves.
+func (m *Mission) templateContext() map[string]interface{} {
+	stages := make(map[string]interface{}, len(m.Stages))
+	for _, s := range m.Stages {
+		outputs := make(map[string]interface{}, len(s.Outputs))
+		for k, v := range s.Outputs {
+			outputs[k] = v
+		}
+		stages[s.Name] = map[string]interface{}{"outputs": outputs}
+	}
+	mission := map[string]interface{}{"id": m.Id, "name": m.Name, "start": m.Start}
+	return map[string]interface{}{"mission": mission, "stages": stages}
+}
+
+// renderParams returns the params that the stage should run with, with all templates replaced by their values. The
+// mission's params are rendered first so that stage params can refer to them, then the stage's params are rendered
+// and override any mission params with the same name.
+func (m *Mission) renderParams(s *Stage) (map[string]interface{}, error) {
+	context := m.templateContext()
+
+	missionParams := make(map[string]interface{}, len(m.Params))
+	for k, v := range m.Params {
+		rendered, err := renderValue(v, context)
+		if err != nil {
+			return nil, fmt.Errorf("mission param '%v' could not be rendered: %v", k, err)
+		}
+		missionParams[k] = rendered
+	}
+	context["params"] = missionParams
+
+	params := make(map[string]interface{}, len(missionParams)+len(s.Params))
+	for k, v := range missionParams {
+		params[k] = v
+	}
+	for k, v := range s.Params {
+		rendered, err := renderValue(v, context)
+		if err != nil {
+			return nil, fmt.Errorf("param '%v' could not be rendered: %v", k, err)
+		}
+		params[k] = rendered
+	}
+	return params, nil
+}
+
+// renderValue replaces all templates within a param value, including those within nested objects and lists. If a
+// string consists of a single template then the value is used as is, which allows lists and objects to be passed
+// between stages. Otherwise, values are converted to strings.
+func renderValue(value interface{}, context map[string]interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if match := templatePattern.FindStringSubmatch(v); match != nil && match[0] == strings.TrimSpace(v) && isTemplate(match[1]) {
+			return renderTemplate(match[1], context)
+		}
+		var err error
+		rendered := templatePattern.ReplaceAllStringFunc(v, func(t string) string {
+			expression := templatePattern.FindStringSubmatch(t)[1]
+			if !isTemplate(expression) {
+				return t
+			}
+			value, renderErr := renderTemplate(expression, context)
+			if renderErr != nil {
+				err = renderErr
+				return t
+			}
+			return fmt.Sprint(value)
+		})
+		return rendered, err
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			r, err := renderValue(item, context)
+			if err != nil {
+				return nil, err
+			}
+			rendered[k] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(v))
+		for i, item := range v {
+			r, err := renderValue(item, context)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	}
+	return value, nil
+}
+
+// renderTemplate parses and evaluates a single template expression.
+func renderTemplate(expression string, context map[string]interface{}) (interface{}, error) {
+	t, err := parseTemplate(expression)
+	if err != nil {
+		return nil, err
+	}
+	return t.evaluate(context)
+}
+
+// SetOutputs stores the outputs of a stage so that they can be used in the params of other stages. The stage must be
+// in progress, and outputs are normally given when the stage is finished.
+func (m *Mission) SetOutputs(stageName string, outputs map[string]interface{}) error {
+	s, err := m.GetStage(stageName)
+	if err != nil {
+		return err
+	}
+	if s.State != started {
+		return &StageChangeError{fmt.Sprintf("cannot set outputs of stage '%v' because it is %v, not started", stageName, s.State)}
+	}
+	s.Outputs = outputs
+	return nil
+}
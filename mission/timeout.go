@@ -0,0 +1,107 @@
+package mission
+
+import (
+	"fmt"
+	"time"
+)
+
+// validateTimeout checks that a timeout is a valid positive duration, e.g. '30m'. Empty timeouts are allowed.
+func validateTimeout(timeout string) error {
+	if timeout == "" {
+		return nil
+	}
+	if d, err := time.ParseDuration(timeout); err != nil || d <= 0 {
+		return fmt.Errorf("timeout '%v' is not a valid duration, e.g. '30m'", timeout)
+	}
+	return nil
+}
+
+// Deadline returns the time by which the mission must be complete. If the mission has no timeout then the zero time
+// is returned.
+func (m *Mission) Deadline() time.Time {
+	if m.Timeout == "" || m.Start.IsZero() {
+		return time.Time{}
+	}
+	timeout, _ := time.ParseDuration(m.Timeout) // timeout has been validated
+	return m.Start.Add(timeout)
+}
+
+// pastDeadline is true if the mission has a timeout and it has elapsed.
+func (m *Mission) pastDeadline(now time.Time) bool {
+	deadline := m.Deadline()
+	return !deadline.IsZero() && now.After(deadline)
+}
+
+// overdue is true if the stage is in progress and has been running for longer than its timeout. Map stages are never
+// overdue because their instances are timed out instead.
+func (s *Stage) overdue(now time.Time) bool {
+	if s.State != started || s.Timeout == "" || s.isMapStage() {
+		return false
+	}
+	timeout, _ := time.ParseDuration(s.Timeout) // timeout has been validated
+	return now.After(s.Start.Add(timeout))
+}
+
+// HasOverdueStages is true if FailOverdueStages would change the mission. This allows the API to check a mission
+// without needing a transaction.
+func (m *Mission) HasOverdueStages(now time.Time) bool {
+	if m.isComplete || !m.End.IsZero() {
+		return false
+	}
+	for _, s := range m.Stages {
+		if s.overdue(now) {
+			return true
+		}
+		if m.pastDeadline(now) && (s.State == started || s.State == ready || s.willRetry()) {
+			return true
+		}
+	}
+	return false
+}
+
+// FailOverdueStages marks all stages that have exceeded their timeout as failed, and returns their names. Stages that
+// time out can be retried if they have a retry policy. If the mission has exceeded its own timeout then all stages in
+// progress are failed without the possibility of a retry, all stages that haven't started are excluded, and the mission
+// is ended, even though it has failed stages.
+func (m *Mission) FailOverdueStages(now time.Time) []string {
+	var timedOut []string
+	if m.isComplete || !m.End.IsZero() {
+		return timedOut
+	}
+	missionTimedOut := m.pastDeadline(now)
+
+	for _, s := range m.Stages {
+		switch {
+		case missionTimedOut && s.State == started:
+			s.Reason = fmt.Sprintf("timed out: mission did not complete within %v", m.Timeout)
+			m.setState(s, failed, s.Reason)
+			s.End = now
+			s.Fatal = true
+			s.Lease = nil
+			timedOut = append(timedOut, s.Name)
+		case missionTimedOut && s.State == ready:
+			m.setState(s, excluded, fmt.Sprintf("mission did not complete within %v", m.Timeout))
+		case missionTimedOut && s.willRetry():
+			s.Fatal = true // prevent failed stages from being retried
+		case s.overdue(now):
+			s.Reason = fmt.Sprintf("timed out: stage did not finish within %v", s.Timeout)
+			m.setState(s, failed, s.Reason)
+			s.End = now
+			s.Lease = nil
+			timedOut = append(timedOut, s.Name)
+		}
+	}
+
+	m.propagate()
+	if missionTimedOut {
+		m.isComplete = true
+		m.End = now
+	}
+	return timedOut
+}
+
+// TimedOut is true if the mission was ended after its timeout elapsed.
+func (m *Mission) TimedOut() bool {
+	deadline := m.Deadline()
+	return !deadline.IsZero() && !m.End.IsZero() && m.End.After(deadline)
+}
@@ -0,0 +1,119 @@
+package mission
+
+import "fmt"
+
+// Trigger rules determine when a stage can run based on the states of its upstream stages.
+const (
+	allSuccess = "all_success" // default: every upstream stage has finished (or been excluded)
+	allDone    = "all_done"    // every upstream stage has finished, failed, or been excluded
+	oneSuccess = "one_success" // at least one upstream stage has finished
+	oneFailed  = "one_failed"  // at least one upstream stage has failed
+	noneFailed = "none_failed" // every upstream stage has finished, or been skipped or excluded
+)
+
+var triggerRules = []string{allSuccess, allDone, oneSuccess, oneFailed, noneFailed}
+
+// validateTriggerRule checks that a trigger rule is one of the known rules. Empty rules default to all_success.
+func validateTriggerRule(rule string) error {
+	if rule == "" || contains(triggerRules, rule) {
+		return nil
+	}
+	return fmt.Errorf("trigger rule '%v' is not valid; choose one of %v", rule, triggerRules)
+}
+
+// failedPermanently is true if a failed stage can never be finished, because its retry policy won't allow it.
+func (s *Stage) failedPermanently() bool {
+	return s.State == failed && (s.Fatal || s.retriesExhausted())
+}
+
+// hasFailed is true if a stage has failed and isn't waiting to be retried by its retry policy.
+func (s *Stage) hasFailed() bool {
+	return s.State == failed && !s.willRetry()
+}
+
+// isDone is true if a stage isn't expected to change state again.
+func (s *Stage) isDone() bool {
+	switch s.State {
+	case finished, excluded, skipped:
+		return true
+	case failed:
+		return s.hasFailed()
+	}
+	return false
+}
+
+// evaluateTriggerRule determines whether a stage's trigger rule is satisfied by the current state of its upstream
+// stages, and whether it can still be satisfied at some point in the future. If the rule isn't satisfied, the first
+// upstream stage that is preventing the stage from running is returned so that a helpful error can be given.
+func (m *Mission) evaluateTriggerRule(s *Stage) (satisfied bool, possible bool, blocking *Stage) {
+	upstream := m.graph.effectiveUpstream(s, s.TriggerRule != noneFailed)
+	if len(upstream) == 0 {
+		return true, true, nil
+	}
+
+	switch s.TriggerRule {
+	case "", allSuccess, noneFailed:
+		possible = true
+		for _, u := range upstream {
+			switch {
+			case u.State == finished, u.State == excluded, u.State == skipped:
+				continue
+			case u.failedPermanently():
+				possible = false
+			}
+			if blocking == nil {
+				blocking = u
+			}
+		}
+		return blocking == nil, possible, blocking
+
+	case allDone:
+		for _, u := range upstream {
+			if !u.isDone() {
+				return false, true, u
+			}
+		}
+		return true, true, nil
+
+	case oneSuccess, oneFailed:
+		allDone := true
+		for _, u := range upstream {
+			if (s.TriggerRule == oneSuccess && u.State == finished) || (s.TriggerRule == oneFailed && u.hasFailed()) {
+				return true, true, nil
+			}
+			if !u.isDone() {
+				allDone = false
+				if blocking == nil {
+					blocking = u
+				}
+			}
+		}
+		if blocking == nil {
+			blocking = upstream[0]
+		}
+		return false, !allDone, blocking
+	}
+
+	return false, false, nil
+}
+
+// skipUnsatisfiableStages skips every ready stage whose trigger rule can no longer be satisfied, e.g. a stage that
+// should only run if an upstream stage fails when all upstream stages have finished, or an on_failure handler when the
+// stage it handles has finished. This allows the mission to complete. Skipping a stage can make other trigger rules
+// unsatisfiable, so this repeats until nothing changes. Returns true if any stages were skipped.
+func (m *Mission) skipUnsatisfiableStages() (skippedAny bool) {
+	for changed := true; changed; {
+		changed = false
+		for _, s := range m.Stages {
+			if s.State != ready {
+				continue
+			}
+			if _, possible, reason := m.dependencyStatus(s); !possible {
+				m.setState(s, skipped, fmt.Sprintf("skipped automatically because %v", reason))
+				changed = true
+				skippedAny = true
+			}
+		}
+	}
+	return skippedAny
+}
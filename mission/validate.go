@@ -0,0 +1,197 @@
+package mission
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Problem is an issue found when validating a plan or mission. Problems with 'error' severity make the plan invalid,
+// whereas warnings are only reported.
+type Problem struct {
+	Code     string `json:"code"`
+	Severity string `json:"severity"`
+	Path     string `json:"path"`            // location of the problem in the plan, e.g. 'stages[2].upstream[0]'
+	Stage    string `json:"stage,omitempty"` // only given if the problem is with a stage
+	Message  string `json:"message"`
+}
+
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%v (%v at %v)", p.Message, p.Code, p.Path)
+}
+
+// problems collects problems found during validation.
+type problems []Problem
+
+func (ps *problems) add(code string, path string, stage string, format string, a ...interface{}) {
+	*ps = append(*ps, Problem{code, SeverityError, path, stage, fmt.Sprintf(format, a...)})
+}
+
+func (ps *problems) warn(code string, path string, stage string, format string, a ...interface{}) {
+	*ps = append(*ps, Problem{code, SeverityWarning, path, stage, fmt.Sprintf(format, a...)})
+}
+
+// stagePath gives the path to a stage or one of its attributes, e.g. 'stages[2].upstream[0]'.
+func stagePath(i int, attribute ...string) string {
+	return fmt.Sprintf("stages[%v]", i) + strings.Join(append([]string{""}, attribute...), ".")
+}
+
+// Validate tests mission is valid, and returns a PlanValidationError listing every error found if it isn't. Warnings
+// are left out, so that the error only gives the reasons the mission is invalid. See Problems for the checks that are
+// made.
+func (m *Mission) Validate() error {
+	var errors []Problem
+	for _, p := range m.Problems() {
+		if p.Severity == SeverityError {
+			errors = append(errors, p)
+		}
+	}
+	return validationError(errors)
+}
+
+// validationError returns a PlanValidationError listing the problems given, or nil if there aren't any.
+func validationError(errors []Problem) error {
+	if len(errors) == 0 {
+		return nil
+	}
+	detail := errors[0].Message
+	if len(errors) > 1 {
+		detail += fmt.Sprintf(" (and %v more problems)", len(errors)-1)
+	}
+	return &PlanValidationError{Detail: detail, Problems: errors}
+}
+
+// Problems checks every aspect of the mission and returns all problems found, rather than stopping at the first one:
+// - more than 0 stages
+// - no duplicate stage names
+// - all referenced stages exist, including on_success and on_failure handlers
+// - all referenced services exist, if the mission defines any services
+// - graph is not cyclic, following both normal and on_success/on_failure links
+// - finalizers are not linked to any other stages
+// - graph is contiguous (no orphaned stages)
+// - retry policies, timeouts, and trigger rules are valid
+// - map stages and their instances are consistent
+// - sub-plan stages refer to another plan
+// - templates in params can be parsed and refer to values that can exist
+func (m *Mission) Problems() []Problem {
+	var ps problems
+
+	// are there more than 0 stages?
+	if len(m.Stages) == 0 {
+		ps.add("no_stages", "stages", "", "plans must have more than 0 stages")
+		return ps
+	}
+
+	// are there any duplicate stage names?
+	seen := make(map[string]bool)
+	for i, s := range m.Stages {
+		if seen[s.Name] {
+			ps.add("duplicate_stage", stagePath(i, "name"), s.Name, "stage name '%v' is not unique", s.Name)
+		}
+		seen[s.Name] = true
+	}
+
+	// are all stages referred to in upstream/downstream defined?
+	for i, s := range m.Stages {
+		for j, u := range s.Upstream {
+			if !seen[u] {
+				ps.add("undefined_upstream", stagePath(i, fmt.Sprintf("upstream[%v]", j)), s.Name, "stage '%v' has upstream dependency '%v' which is not defined", s.Name, u)
+			}
+		}
+		for j, d := range s.Downstream {
+			if !seen[d] {
+				ps.add("undefined_downstream", stagePath(i, fmt.Sprintf("downstream[%v]", j)), s.Name, "stage '%v' has downstream dependency '%v' which is not defined", s.Name, d)
+			}
+		}
+		for j, h := range s.OnFailure {
+			if !seen[h] {
+				ps.add("undefined_handler", stagePath(i, fmt.Sprintf("on_failure[%v]", j)), s.Name, "stage '%v' has handler stage '%v' which is not defined", s.Name, h)
+			}
+		}
+		for j, h := range s.OnSuccess {
+			if !seen[h] {
+				ps.add("undefined_handler", stagePath(i, fmt.Sprintf("on_success[%v]", j)), s.Name, "stage '%v' has handler stage '%v' which is not defined", s.Name, h)
+			}
+		}
+		if len(m.Services) > 0 && s.Service != "" && s.SubPlan() == "" && !contains(m.Services, s.Service) {
+			ps.add("undefined_service", stagePath(i, "service"), s.Name, "stage '%v' uses service '%v' which is not defined in the plan", s.Name, s.Service)
+		}
+	}
+
+	// finalizers run after all other stages, so can't be linked to them
+	for i, s := range m.Stages {
+		if s.Finalizer && (len(m.graph.up[s]) > 0 || len(m.graph.down[s]) > 0 || len(m.graph.handlers[s]) > 0 || len(m.graph.handles[s]) > 0) {
+			ps.add("linked_finalizer", stagePath(i, "finalizer"), s.Name, "stage '%v' is a finalizer so can't have upstream, downstream, or handler stages", s.Name)
+		}
+	}
+
+	// is graph cyclic?
+	for _, cycle := range m.graph.FindCycles(m.Stages) {
+		names := make([]string, len(cycle))
+		for i, s := range cycle {
+			names[i] = s.Name
+		}
+		ps.add("cycle", stagePath(m.stageIndex(cycle[0])), cycle[0].Name, "stage '%v' is dependent on itself (infinite loop): %v", cycle[0].Name, strings.Join(names, " > "))
+	}
+
+	// is graph contiguous?
+	// follow every path forwards and backwards from a single node and check that every node was visited at least once
+	if unreachable := m.graph.UnreachableStages(m.Stages); len(unreachable) > 0 {
+		startingStage := m.Stages[0]
+		for _, s := range m.Stages {
+			if !s.Finalizer {
+				startingStage = s
+				break
+			}
+		}
+		for _, s := range unreachable {
+			ps.add("unreachable_stage", stagePath(m.stageIndex(s)), s.Name, "invalid plan: not contiguous - '%v' cannot be reached from '%v'", s.Name, startingStage.Name)
+		}
+	}
+
+	// are all retry policies, timeouts, and params valid?
+	for i, s := range m.Stages {
+		if s.Retry != nil {
+			if err := s.Retry.validate(); err != nil {
+				ps.add("invalid_retry_policy", stagePath(i, "retry"), s.Name, "stage '%v' has an invalid retry policy: %v", s.Name, err)
+			}
+		}
+		if err := validateTimeout(s.Timeout); err != nil {
+			ps.add("invalid_timeout", stagePath(i, "timeout"), s.Name, "stage '%v' has an invalid timeout: %v", s.Name, err)
+		}
+		if err := validateTriggerRule(s.TriggerRule); err != nil {
+			ps.add("invalid_trigger_rule", stagePath(i, "trigger_rule"), s.Name, "stage '%v' has an invalid trigger rule: %v", s.Name, err)
+		}
+		if err := m.validateMapStage(s); err != nil {
+			ps.add("invalid_map_stage", stagePath(i), s.Name, "stage '%v' is not valid: %v", s.Name, err)
+		}
+		if err := m.validateSubPlan(s); err != nil {
+			ps.add("invalid_sub_plan", stagePath(i, "service"), s.Name, "stage '%v' is not valid: %v", s.Name, err)
+		}
+		if err := m.validateTemplates(s.Params, true); err != nil {
+			ps.add("invalid_params", stagePath(i, "params"), s.Name, "stage '%v' has invalid params: %v", s.Name, err)
+		}
+	}
+	if err := validateTimeout(m.Timeout); err != nil {
+		ps.add("invalid_timeout", "timeout", "", "plan has an invalid timeout: %v", err)
+	}
+	if err := m.validateTemplates(m.Params, false); err != nil {
+		ps.add("invalid_params", "params", "", "plan has invalid params: %v", err)
+	}
+
+	return ps
+}
+
+// stageIndex returns the position of a stage in the mission's list of stages.
+func (m *Mission) stageIndex(s *Stage) int {
+	for i, other := range m.Stages {
+		if other == s {
+			return i
+		}
+	}
+	return -1
+}
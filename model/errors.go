@@ -1,6 +1,10 @@
 package model
 
-import "net/http"
+import (
+	"net/http"
+
+	"github.com/datasparq-ai/houston/mission"
+)
 
 // ErrorCode maps all Houston errors to unique codes for easy identification
 func ErrorCode(err error) int {
@@ -13,8 +17,10 @@ func ErrorCode(err error) int {
 		return http.StatusUnauthorized
 	case *KeyNotFoundError:
 		return 470
-	case *PlanNotFoundError:
+	case *PlanNotFoundError, *PlanVersionNotFoundError, *MissionNotFoundError:
 		return http.StatusNotFound
+	case *PreconditionFailedError:
+		return http.StatusPreconditionFailed
 	case *BadCredentialsError:
 		return http.StatusForbidden
 	case *InternalError:
@@ -50,6 +56,41 @@ func (m *PlanNotFoundError) Error() string {
 	return "Plan '" + m.PlanName + "' not found."
 }
 
+type PlanVersionNotFoundError struct {
+	PlanName string
+	Version  string
+}
+
+func (m *PlanVersionNotFoundError) Error() string {
+	return "Version " + m.Version + " of plan '" + m.PlanName + "' not found."
+}
+
+type MissionNotFoundError struct {
+	MissionId string
+}
+
+func (m *MissionNotFoundError) Error() string {
+	return "Mission '" + m.MissionId + "' not found."
+}
+
+type PreconditionFailedError struct {
+	PlanName string
+	ETag     string
+}
+
+func (m *PreconditionFailedError) Error() string {
+	return "Plan '" + m.PlanName + "' has been modified since it was retrieved. The ETag of the current version is " + m.ETag + "."
+}
+
+// InvalidRequestError is returned when the body of a request isn't valid JSON or doesn't match the expected schema.
+type InvalidRequestError struct {
+	Problems []mission.Problem
+}
+
+func (m *InvalidRequestError) Error() string {
+	return "Request is invalid: " + m.Problems[0].Message
+}
+
 type TooManyRequestsError struct{}
 
 func (m *TooManyRequestsError) Error() string {
@@ -1,6 +1,9 @@
 package model
 
-import "github.com/datasparq-ai/houston/mission"
+import (
+	"github.com/datasparq-ai/houston/mission"
+	"time"
+)
 
 type Error struct {
 	Type    string `json:"type"`
@@ -8,6 +11,14 @@ type Error struct {
 	Code    int    `json:"code"`
 }
 
+// ValidationError is returned instead of Error when a plan or request is invalid, and lists every problem found.
+type ValidationError struct {
+	Type     string            `json:"type"`
+	Message  string            `json:"message"`
+	Code     int               `json:"code"`
+	Problems []mission.Problem `json:"problems"`
+}
+
 type Success struct {
 	Message string `json:"message"`
 }
@@ -25,10 +36,37 @@ type MissionStage mission.Stage
 
 type MissionStageStateUpdateResponse mission.Response
 
+type MissionStageTransition mission.Transition
+
 type MissionCreateRequest struct {
 	Plan   string                 `json:"plan"`
 	Id     string                 `json:"id"`
-	Params map[string]interface{} `json:"params"` // TODO: update plan params with mission params
+	Params map[string]interface{} `json:"params"` // override the plan's params, and are overridden by stage params
+}
+
+// PlanLintResponse lists every problem found in a plan. Valid is false if any of the problems are errors, which would
+// prevent the plan from being saved.
+type PlanLintResponse struct {
+	Valid    bool              `json:"valid"`
+	Problems []mission.Problem `json:"problems"`
+}
+
+// PlanDryRunRequest gives the options that a mission would be started with, which are the same as 'houston start'.
+type PlanDryRunRequest struct {
+	Stages  []string               `json:"stages"` // stages to start from, ignoring their upstream dependencies
+	Exclude []string               `json:"exclude"`
+	Skip    []string               `json:"skip"`
+	Params  map[string]interface{} `json:"params"`
+}
+
+type PlanDryRunResponse = mission.ExecutionPlan
+
+// RelatedStagesResponse lists the stages upstream or downstream of a stage, ordered by the number of links between
+// them.
+type RelatedStagesResponse struct {
+	Stage     string                 `json:"stage"`
+	Direction string                 `json:"direction"` // either 'upstream' or 'downstream'
+	Stages    []mission.RelatedStage `json:"stages"`
 }
 
 type MissionCreatedResponse struct {
@@ -36,23 +74,125 @@ type MissionCreatedResponse struct {
 }
 
 type MissionStageStateUpdate struct {
-	State              string `json:"state"`
-	IgnoreDependencies bool   `json:"ignoreDependencies"`
+	State              string                 `json:"state"`
+	IgnoreDependencies bool                   `json:"ignoreDependencies"`
+	Fatal              bool                   `json:"fatal"`             // only used when failing a stage; fatal failures won't be retried
+	Outputs            map[string]interface{} `json:"outputs,omitempty"` // only used when finishing a stage; can be used in downstream params
+	Error              *MissionStageError     `json:"error,omitempty"`   // only used when failing a stage
+	Message            string                 `json:"message,omitempty"` // recorded in the stage's history
+	Worker             string                 `json:"worker,omitempty"`  // only given by workers, which can only update stages leased to them
+	Caller             string                 `json:"-"`                 // set by the API to the name of the key, or the caller's IP address
+}
+
+// MissionStageError describes why a stage failed. Errors that are explicitly not retryable are treated as fatal
+// failures, whereas errors that don't say whether they are retryable leave it to the stage's retry policy.
+type MissionStageError struct {
+	Message   string `json:"message"`
+	Type      string `json:"type,omitempty"`
+	Stack     string `json:"stack,omitempty"`     // a stack trace or an excerpt of the service's logs
+	Retryable *bool  `json:"retryable,omitempty"` // only false prevents the stage from being retried
+}
+
+type MissionCancelRequest struct {
+	Reason string `json:"reason"`
+	Actor  string `json:"actor"` // defaults to the name of the key, or the caller's IP address
 }
 
 type Stage struct {
-	Name       string                 `json:"name" key:"n"`
-	Service    string                 `json:"service" key:"a"`
-	Upstream   []string               `json:"upstream" key:"u"`
-	Downstream []string               `json:"downstream" key:"d"`
-	Params     map[string]interface{} `json:"params" key:"p"`
+	Name        string                 `json:"name" key:"n"`
+	Service     string                 `json:"service" key:"a"`
+	Upstream    []string               `json:"upstream" key:"u"`
+	Downstream  []string               `json:"downstream" key:"d"`
+	Params      map[string]interface{} `json:"params" key:"p"`
+	Retry       *RetryPolicy           `json:"retry,omitempty" key:"r"`
+	Timeout     string                 `json:"timeout,omitempty" key:"o"`
+	TriggerRule string                 `json:"trigger_rule,omitempty" key:"w"`
+	OnFailure   []string               `json:"on_failure,omitempty" key:"b"`
+	OnSuccess   []string               `json:"on_success,omitempty" key:"g"`
+	Finalizer   bool                   `json:"finalizer,omitempty" key:"z"`
+	MapOver     string                 `json:"map_over,omitempty" key:"v"`
+	X           *int                   `json:"x,omitempty" key:"x"` // pins the stage's position in the layout, if given with Y
+	Y           *int                   `json:"y,omitempty" key:"y"`
+}
+
+// RetryPolicy defines how a failed stage is retried, see mission.RetryPolicy.
+type RetryPolicy struct {
+	MaxAttempts int    `json:"max_attempts" key:"m"`
+	Backoff     string `json:"backoff" key:"b"`
+	Delay       string `json:"delay" key:"l"`
 }
 
 type Plan struct {
-	Name     string                 `json:"name" key:"n"`
-	Services []Service              `json:"services" key:"a"`
-	Stages   []*Stage               `json:"stages" key:"s"`
-	Params   map[string]interface{} `json:"params" key:"p"`
+	Name     string                      `json:"name" key:"n"`
+	Services []Service                   `json:"services" key:"a"`
+	Stages   []*Stage                    `json:"stages" key:"s"`
+	Params   map[string]interface{}      `json:"params" key:"p"`
+	Timeout  string                      `json:"timeout,omitempty" key:"o"`
+	Version  int                         `json:"version,omitempty" key:"v"` // set when the plan is saved
+	Layout   map[string]mission.Position `json:"layout,omitempty" key:"l"`  // position of every stage, set when the plan is saved
+}
+
+// PlanVersion describes a saved version of a plan. Every time a plan is saved it is given a new version number.
+type PlanVersion struct {
+	Version int       `json:"version"`
+	Time    time.Time `json:"time"`
+	Author  string    `json:"author"` // the name of the key, or the IP address, that saved the plan
+}
+
+// PlanDiff lists the differences between two versions of a plan.
+type PlanDiff struct {
+	From    int         `json:"from"`
+	To      int         `json:"to"`
+	Plan    []string    `json:"plan"`    // plan attributes that changed, e.g. params
+	Added   []string    `json:"added"`   // names of stages that were added
+	Removed []string    `json:"removed"` // names of stages that were removed
+	Changed []StageDiff `json:"changed"`
+}
+
+// StageDiff lists the attributes of a stage that changed between two versions of a plan.
+type StageDiff struct {
+	Name       string   `json:"name"`
+	Attributes []string `json:"attributes"`
+}
+
+// WorkLeaseRequest asks for a stage that is ready to run, for a worker that runs the services given.
+type WorkLeaseRequest struct {
+	Worker   string   `json:"worker"` // name of the worker, recorded in the stage's history
+	Services []string `json:"services"`
+	Duration string   `json:"duration,omitempty"` // how long the lease lasts, e.g. '5m', defaults to Config.LeaseDuration
+}
+
+// WorkHeartbeatRequest renews the lease of a stage that a worker is running.
+type WorkHeartbeatRequest struct {
+	Worker    string `json:"worker"`
+	MissionId string `json:"missionId"`
+	Stage     string `json:"stage"`
+	Duration  string `json:"duration,omitempty"`
+}
+
+// WorkLease is a stage that has been started and leased to a worker. The worker must finish or fail the stage, or
+// renew the lease, before it expires.
+type WorkLease struct {
+	Plan      string                 `json:"plan"`
+	MissionId string                 `json:"missionId"`
+	Stage     string                 `json:"stage"`
+	Service   string                 `json:"service"`
+	Params    map[string]interface{} `json:"params"` // the stage's params, with templates rendered
+	Expiry    time.Time              `json:"expiry"`
+}
+
+// WorkLeaseResponse gives the stage leased to a worker. Lease is nil if no stages are ready to run.
+type WorkLeaseResponse struct {
+	Lease *WorkLease `json:"lease"`
+}
+
+// TriggerMessage is sent to a service to trigger a stage, see docs/services.md.
+type TriggerMessage struct {
+	Plan               string `json:"plan"`
+	MissionId          string `json:"mission_id"`
+	Stage              string `json:"stage"`
+	IgnoreDependencies bool   `json:"ignore_dependencies"`
+	IgnoreDependants   bool   `json:"ignore_dependants"`
 }
 
 type Service struct {